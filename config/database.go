@@ -1,20 +1,127 @@
 package config
 
 import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+)
 
-	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+// Database drivers for DB_DRIVER
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
 )
 
-func InitDB() *gorm.DB {
-	db, err := gorm.Open(sqlite.Open("data/event.db"), &gorm.Config{})
+const defaultSQLiteDSN = "data/event.db"
+
+// sqlitePragmas put SQLite in WAL mode, so pages can be read while a match is
+// being saved, and make writers wait for each other instead of failing
+const sqlitePragmas = "_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+
+// OpenDB connects to a database. An empty driver means SQLite, and an empty
+// SQLite DSN means data/event.db. A Postgres DSN is either a URL or a list of
+// key=value settings.
+func OpenDB(driver string, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch driver {
+	case "", DriverSQLite:
+		if dsn == "" {
+			dsn = defaultSQLiteDSN
+		}
+		if !strings.Contains(dsn, "_pragma=") {
+			separator := "?"
+			if strings.Contains(dsn, "?") {
+				separator = "&"
+			}
+			dsn += separator + sqlitePragmas
+		}
+		dialector = sqlite.Open(dsn)
+	case DriverPostgres:
+		if dsn == "" {
+			return nil, fmt.Errorf("DB_DSN is required for %s", driver)
+		}
+		dialector = postgres.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q, use %s or %s", driver, DriverSQLite, DriverPostgres)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
-		panic("failed to connect to database")
+		return nil, err
+	}
+	if err := configurePool(db); err != nil {
+		return nil, err
 	}
+	return db, nil
+}
 
-	db.AutoMigrate(&models.User{})
-	db.AutoMigrate(&models.QualsMatch{})
-	db.AutoMigrate(&models.AllianceSelection{})
+// configurePool sizes the connection pool from DB_MAX_OPEN_CONNS,
+// DB_MAX_IDLE_CONNS and DB_CONN_MAX_LIFETIME
+func configurePool(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	maxOpen, err := envInt("DB_MAX_OPEN_CONNS", 10)
+	if err != nil {
+		return err
+	}
+	maxIdle, err := envInt("DB_MAX_IDLE_CONNS", 5)
+	if err != nil {
+		return err
+	}
+	lifetime := 30 * time.Minute
+	if value := os.Getenv("DB_CONN_MAX_LIFETIME"); value != "" {
+		if lifetime, err = time.ParseDuration(value); err != nil {
+			return fmt.Errorf("invalid DB_CONN_MAX_LIFETIME %q", value)
+		}
+	}
+
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(maxIdle)
+	sqlDB.SetConnMaxLifetime(lifetime)
+	return nil
+}
+
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return number, nil
+}
+
+// CloseDB closes every connection in the pool
+func CloseDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// ConnectDB connects to the database named by DB_DRIVER and DB_DSN
+func ConnectDB() (*gorm.DB, error) {
+	return OpenDB(os.Getenv("DB_DRIVER"), os.Getenv("DB_DSN"))
+}
+
+// InitDB connects to the database and applies any pending migrations
+func InitDB() *gorm.DB {
+	db, err := ConnectDB()
+	if err != nil {
+		panic("failed to connect to database: " + err.Error())
+	}
+	if err := MigrateUp(db); err != nil {
+		panic("failed to migrate database: " + err.Error())
+	}
 	return db
 }
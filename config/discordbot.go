@@ -1,24 +1,33 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-func InitDiscordBot() *discordgo.Session {
+// InitDiscordBot connects the bot. It fails without DISCORD_BOT_TOKEN or when
+// Discord can't be reached, in which case the server runs without it.
+func InitDiscordBot() (*discordgo.Session, error) {
+	token := os.Getenv("DISCORD_BOT_TOKEN")
+	if token == "" {
+		return nil, errors.New("DISCORD_BOT_TOKEN is not set")
+	}
+
 	// Create a new Discord session using the provided token
-	dg, err := discordgo.New("Bot " + os.Getenv("DISCORD_BOT_TOKEN"))
+	dg, err := discordgo.New("Bot " + token)
 	if err != nil {
-		panic("Error creating Discord session: " + err.Error())
+		return nil, fmt.Errorf("error creating Discord session: %v", err)
 	}
 
 	// Open a websocket connection to Discord
 	if err := dg.Open(); err != nil {
-		panic("Error opening Discord session: " + err.Error())
+		return nil, fmt.Errorf("error opening Discord session: %v", err)
 	}
 
 	dg.UpdateWatchStatus(0, "Robotics")
 
-	return dg
+	return dg, nil
 }
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// Migration is one versioned change to the schema. Up and Down each run in a
+// single transaction along with recording the change, so a failed step
+// leaves the database as it was.
+type Migration struct {
+	Version     int
+	Name        string
+	Up          func(tx *gorm.DB) error
+	Down        func(tx *gorm.DB) error
+	Destructive bool // Down throws away the event, so it's only reverted when forced
+}
+
+// appliedMigrations returns the versions recorded in the schema table
+func appliedMigrations(db *gorm.DB) (map[int]bool, int, error) {
+	if err := db.AutoMigrate(&models.SchemaMigration{}); err != nil {
+		return nil, 0, err
+	}
+	var recorded []models.SchemaMigration
+	if err := db.Order("version").Find(&recorded).Error; err != nil {
+		return nil, 0, err
+	}
+	applied := make(map[int]bool, len(recorded))
+	latest := 0
+	for _, migration := range recorded {
+		applied[migration.Version] = true
+		latest = migration.Version
+	}
+	return applied, latest, nil
+}
+
+// MigrateUp applies every migration the database hasn't had yet, in order.
+// It refuses to touch a database migrated by a newer build, and backs up a
+// SQLite database before changing a schema that already holds an event.
+func MigrateUp(db *gorm.DB) error {
+	applied, latest, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	known := Migrations[len(Migrations)-1].Version
+	if latest > known {
+		return fmt.Errorf("database is at schema version %d but this build only knows up to %d; upgrade the app instead", latest, known)
+	}
+
+	var pending []Migration
+	for _, migration := range Migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if len(applied) > 0 || db.Migrator().HasTable(&models.User{}) {
+		if err := backupSQLite(db, fmt.Sprintf("before-v%d", pending[0].Version)); err != nil {
+			return fmt.Errorf("backing up the database before migrating: %v", err)
+		}
+	}
+
+	for _, migration := range pending {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&models.SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+		log.Printf("Applied migration %d: %s", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+// MigrateDown reverts the latest steps applied migrations, newest first. A
+// SQLite database is backed up first, and destructive migrations are only
+// reverted when forced.
+func MigrateDown(db *gorm.DB, steps int, force bool) error {
+	applied, latest, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	var reverting []Migration
+	for i := len(Migrations) - 1; i >= 0 && len(reverting) < steps; i-- {
+		if applied[Migrations[i].Version] {
+			reverting = append(reverting, Migrations[i])
+		}
+	}
+	for _, migration := range reverting {
+		if migration.Destructive && !force {
+			return fmt.Errorf("reverting migration %d (%s) deletes the whole event; use --force to do it anyway", migration.Version, migration.Name)
+		}
+	}
+	if len(reverting) == 0 {
+		return nil
+	}
+
+	if err := backupSQLite(db, fmt.Sprintf("before-rollback-v%d", latest)); err != nil {
+		return fmt.Errorf("backing up the database before rolling back: %v", err)
+	}
+
+	for _, migration := range reverting {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&models.SchemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+		log.Printf("Reverted migration %d: %s", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+// backupSQLite copies a SQLite database file next to itself with the suffix.
+// Other databases and in-memory SQLite are left to their own backups.
+func backupSQLite(db *gorm.DB, suffix string) error {
+	if db.Dialector.Name() != "sqlite" {
+		return nil
+	}
+	var databases []struct {
+		Seq  int
+		Name string
+		File string
+	}
+	if err := db.Raw("PRAGMA database_list").Scan(&databases).Error; err != nil {
+		return err
+	}
+	for _, database := range databases {
+		if database.Name != "main" || database.File == "" {
+			continue
+		}
+		backup := database.File + "." + suffix
+		if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := db.Exec("VACUUM INTO ?", backup).Error; err != nil {
+			return err
+		}
+		log.Printf("Backed up the database to %s", backup)
+	}
+	return nil
+}
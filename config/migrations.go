@@ -0,0 +1,352 @@
+package config
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations is every schema change in the order it was made. Append new
+// ones with the next version and never edit one that has shipped. Each
+// migration keeps its own copy of the tables it touches, so later changes
+// to the models don't change what an old migration does.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "baseline schema",
+		// Databases created before migrations existed already have some of
+		// these tables; AutoMigrate only adds what's missing.
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(baselineTables...)
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(baselineTables...)
+		},
+		Destructive: true,
+	},
+	{
+		Version: 2,
+		Name:    "ranking snapshots",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&rankingSnapshotV2{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&rankingSnapshotV2{})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "ranking snapshots store only changes",
+		Up:      rankingChangesUp,
+		Down:    rankingChangesDown,
+	},
+	{
+		Version: 4,
+		Name:    "webhook retry schedule",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&webhookDeliveryV4{})
+		},
+		Down: func(tx *gorm.DB) error {
+			if err := tx.Migrator().DropIndex(&webhookDeliveryV4{}, "NextAttemptAt"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropColumn(&webhookDeliveryV4{}, "NextAttemptAt"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&webhookDeliveryV4{}, "Retries")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "schedule timing",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&scheduleTimingV5{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&scheduleTimingV5{})
+		},
+	},
+}
+
+var baselineTables = []interface{}{
+	&baselineUser{},
+	&baselineQualsMatch{},
+	&baselineAllianceSelection{},
+	&baselinePlayoffMatch{},
+	&baselineScheduleBlock{},
+	&baselineScheduleBreak{},
+	&baselineDiscordSettings{},
+	&baselineDiscordResource{},
+	&baselineWebhook{},
+	&baselineWebhookDelivery{},
+}
+
+type baselineUser struct {
+	ID               int    `gorm:"primaryKey"`
+	Username         string `gorm:"uniqueIndex"`
+	PreferedUsername string `gorm:"uniqueIndex"`
+	MMID             int    `gorm:"uniqueIndex"`
+	TotalRP          int    `gorm:"default:0"`
+	WinRP            int    `gorm:"default:0"`
+	BonusRP          int    `gorm:"default:0"`
+	TotalPoints      int    `gorm:"default:0"`
+	AutoPoints       int    `gorm:"default:0"`
+	TeleopPoints     int    `gorm:"default:0"`
+	EndgamePoints    int    `gorm:"default:0"`
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineQualsMatch struct {
+	ID               int `gorm:"primaryKey"`
+	RedPlayerID      int `gorm:"not null"`
+	BluePlayerID     int `gorm:"not null"`
+	RedTeleopScore   int
+	BlueTeleopScore  int
+	RedAutoScore     int
+	BlueAutoScore    int
+	RedEndgameScore  int
+	BlueEndgameScore int
+	RedScore         int
+	BlueScore        int
+	RedWinRP         int
+	BlueWinRP        int
+	RedBonusRP       int
+	BlueBonusRP      int
+	ScheduledStart   *time.Time
+	CommittedAt      *time.Time
+	RedSurrogate     bool
+	BlueSurrogate    bool
+}
+
+func (baselineQualsMatch) TableName() string { return "quals_matches" }
+
+type baselineAllianceSelection struct {
+	AllianceNumber    int
+	AllianceCaptain   string
+	AllianceSelection string
+}
+
+func (baselineAllianceSelection) TableName() string { return "alliance_selections" }
+
+type baselinePlayoffMatch struct {
+	ID           int    `gorm:"primaryKey"`
+	Name         string `gorm:"uniqueIndex"`
+	RedAlliance  int    `gorm:"not null"`
+	BlueAlliance int    `gorm:"not null"`
+	RedScore     int
+	BlueScore    int
+}
+
+func (baselinePlayoffMatch) TableName() string { return "playoff_matches" }
+
+type baselineScheduleBlock struct {
+	ID           int `gorm:"primaryKey"`
+	Label        string
+	Start        time.Time `gorm:"not null"`
+	CycleSeconds int       `gorm:"not null"`
+	MatchCount   int
+}
+
+func (baselineScheduleBlock) TableName() string { return "schedule_blocks" }
+
+type baselineScheduleBreak struct {
+	ID              int `gorm:"primaryKey"`
+	AfterMatchID    int `gorm:"not null"`
+	DurationSeconds int `gorm:"not null"`
+	Label           string
+	CreatedAt       time.Time
+}
+
+func (baselineScheduleBreak) TableName() string { return "schedule_breaks" }
+
+type baselineDiscordSettings struct {
+	ID                int `gorm:"primaryKey"`
+	MatchChannelID    string
+	ResultsChannelID  string
+	RankingsChannelID string
+	AllianceChannelID string
+	RankingsSize      int
+	RankingsEvery     int
+	MatchCallTemplate string
+	OnDeckTemplate    string
+	ResultsTemplate   string
+	AllianceTemplate  string
+	AdvanceTemplate   string
+	ManageRoles       bool
+	RolesDryRun       bool
+}
+
+func (baselineDiscordSettings) TableName() string { return "discord_settings" }
+
+type baselineDiscordResource struct {
+	ID        int    `gorm:"primaryKey"`
+	Purpose   string `gorm:"uniqueIndex"`
+	Kind      string
+	Name      string
+	DiscordID string
+}
+
+func (baselineDiscordResource) TableName() string { return "discord_resources" }
+
+type baselineWebhook struct {
+	ID        int    `gorm:"primaryKey"`
+	URL       string `gorm:"not null"`
+	Secret    string
+	Events    string
+	Enabled   bool
+	CreatedAt time.Time
+}
+
+func (baselineWebhook) TableName() string { return "webhooks" }
+
+type baselineWebhookDelivery struct {
+	ID          int `gorm:"primaryKey"`
+	WebhookID   int `gorm:"index"`
+	Event       string
+	Payload     string
+	Attempts    int
+	StatusCode  int
+	Error       string
+	Delivered   bool
+	CreatedAt   time.Time
+	DeliveredAt *time.Time
+}
+
+func (baselineWebhookDelivery) TableName() string { return "webhook_deliveries" }
+
+type rankingSnapshotV2 struct {
+	ID        int `gorm:"primaryKey"`
+	Snapshot  int `gorm:"index"`
+	MatchID   int
+	Corrected bool
+	MMID      int `gorm:"index"`
+	Rank      int
+	TotalRP   int
+	TakenAt   time.Time
+}
+
+func (rankingSnapshotV2) TableName() string { return "ranking_snapshots" }
+
+// Version 3 splits each snapshot into a header row and a row for each player
+// whose rank or total RP changed since their previous one
+
+type rankingSnapshotV3 struct {
+	ID        int `gorm:"primaryKey"`
+	MatchID   int
+	Corrected bool
+	TakenAt   time.Time
+}
+
+func (rankingSnapshotV3) TableName() string { return "ranking_snapshots" }
+
+type rankingChangeV3 struct {
+	ID         int `gorm:"primaryKey"`
+	SnapshotID int `gorm:"index"`
+	MMID       int `gorm:"index"`
+	Rank       int
+	TotalRP    int
+}
+
+func (rankingChangeV3) TableName() string { return "ranking_changes" }
+
+func rankingChangesUp(tx *gorm.DB) error {
+	var rows []rankingSnapshotV2
+	if err := tx.Order("snapshot").Order("mm_id").Find(&rows).Error; err != nil {
+		return err
+	}
+	if err := tx.Migrator().DropTable(&rankingSnapshotV2{}); err != nil {
+		return err
+	}
+	if err := tx.AutoMigrate(&rankingSnapshotV3{}, &rankingChangeV3{}); err != nil {
+		return err
+	}
+
+	type standing struct{ rank, totalRP int }
+	last := map[int]standing{}
+	header := rankingSnapshotV3{}
+	var changes []rankingChangeV3
+	for i, row := range rows {
+		if i == 0 || row.Snapshot != rows[i-1].Snapshot {
+			header = rankingSnapshotV3{MatchID: row.MatchID, Corrected: row.Corrected, TakenAt: row.TakenAt}
+			if err := tx.Create(&header).Error; err != nil {
+				return err
+			}
+		}
+		if previous, ok := last[row.MMID]; ok && previous == (standing{row.Rank, row.TotalRP}) {
+			continue
+		}
+		last[row.MMID] = standing{row.Rank, row.TotalRP}
+		changes = append(changes, rankingChangeV3{SnapshotID: header.ID, MMID: row.MMID, Rank: row.Rank, TotalRP: row.TotalRP})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(changes, 100).Error
+}
+
+// rankingChangesDown writes every change back as a version 2 row. Players
+// who didn't change in a snapshot have no row in it.
+func rankingChangesDown(tx *gorm.DB) error {
+	var headers []rankingSnapshotV3
+	if err := tx.Find(&headers).Error; err != nil {
+		return err
+	}
+	var changes []rankingChangeV3
+	if err := tx.Order("snapshot_id").Order("mm_id").Find(&changes).Error; err != nil {
+		return err
+	}
+	if err := tx.Migrator().DropTable(&rankingSnapshotV3{}, &rankingChangeV3{}); err != nil {
+		return err
+	}
+	if err := tx.AutoMigrate(&rankingSnapshotV2{}); err != nil {
+		return err
+	}
+
+	byID := make(map[int]rankingSnapshotV3, len(headers))
+	for _, header := range headers {
+		byID[header.ID] = header
+	}
+	rows := make([]rankingSnapshotV2, len(changes))
+	for i, change := range changes {
+		header := byID[change.SnapshotID]
+		rows[i] = rankingSnapshotV2{
+			Snapshot:  change.SnapshotID,
+			MatchID:   header.MatchID,
+			Corrected: header.Corrected,
+			MMID:      change.MMID,
+			Rank:      change.Rank,
+			TotalRP:   change.TotalRP,
+			TakenAt:   header.TakenAt,
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(rows, 100).Error
+}
+
+type webhookDeliveryV4 struct {
+	ID            int `gorm:"primaryKey"`
+	WebhookID     int `gorm:"index"`
+	Event         string
+	Payload       string
+	Attempts      int
+	StatusCode    int
+	Error         string
+	Delivered     bool
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time
+	Retries       int
+	NextAttemptAt *time.Time `gorm:"index"`
+}
+
+func (webhookDeliveryV4) TableName() string { return "webhook_deliveries" }
+
+type scheduleTimingV5 struct {
+	ID           int `gorm:"primaryKey"`
+	CycleSeconds int `gorm:"not null"`
+	EventStart   *time.Time
+}
+
+func (scheduleTimingV5) TableName() string { return "schedule_timings" }
@@ -7,7 +7,6 @@ import (
 	"runtime"
 	"strconv"
 
-	"github.com/bwmarrin/discordgo"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
@@ -25,6 +24,27 @@ func AdminDashboardHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		blocks, err := services.GetScheduleBlocks(db)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch schedule blocks"})
+			return
+		}
+		breaks, err := services.GetScheduleBreaks(db)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch schedule breaks"})
+			return
+		}
+		webhooks, err := services.GetWebhooks(db)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch webhooks"})
+			return
+		}
+		deliveries, err := services.GetWebhookDeliveries(db, 25)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to fetch webhook deliveries"})
+			return
+		}
+
 		// Try to get first match, but don't fail if none exist
 		var match models.QualsMatch
 		hasMatches := db.First(&match).Error == nil
@@ -32,9 +52,19 @@ func AdminDashboardHandler(db *gorm.DB) gin.HandlerFunc {
 		c.HTML(200, "admin.tmpl", gin.H{
 			"title":            "Admin Dashboard",
 			"isSchedulePublic": GetSchedulePublic(),
-			"matches":          services.ParseMatchScheduleFromDB(),
+			"matches":          services.ParseMatchScheduleFromDB(db),
 			"users":            users,
 			"hasMatches":       hasMatches,
+			"queueDepth":       services.Queue.Depth(),
+			"cycleMinutes":     services.GetCycleTime().Minutes(),
+			"eventStart":       services.GetEventStart(),
+			"blocks":           blocks,
+			"discord":          services.GetDiscordSettings(db),
+			"breaks":           breaks,
+			"webhooks":         webhooks,
+			"deliveries":       deliveries,
+			"webhookEvents":    services.WebhookEvents,
+			"exports":          services.Exports,
 		})
 	}
 }
@@ -50,7 +80,7 @@ func AdminUsersHandler(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-func SetActiveMatchHandler(db *gorm.DB, dg *discordgo.Session) gin.HandlerFunc {
+func SetActiveMatchHandler(db *gorm.DB, notifier services.Notifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get match level and ID from query parameters
 		matchLevel := c.Query("level")
@@ -73,34 +103,9 @@ func SetActiveMatchHandler(db *gorm.DB, dg *discordgo.Session) gin.HandlerFunc {
 			return
 		}
 
-		switch matchLevel {
-		case "Quals":
-			// Broadcast the active match update to all WebSocket clients
-			services.BroadcastActiveMatch(
-				matchLevel,
-				matchID,
-				strconv.Itoa(match.RedPlayerID),
-				strconv.Itoa(match.BluePlayerID),
-				db,
-			)
-
-			var redPlayer, bluePlayer models.User
-
-			// Find users by MMID
-			db.Where("mm_id = ?", match.RedPlayerID).First(&redPlayer)
-			db.Where("mm_id = ?", match.BluePlayerID).First(&bluePlayer)
-
-			dg.ChannelMessageSend(
-				os.Getenv("DISCORD_CHANNEL_ID"),
-				"Quals "+strconv.Itoa(matchID)+" will be <@"+strconv.Itoa(redPlayer.ID)+"> vs. <@"+strconv.Itoa(bluePlayer.ID)+">")
-		case "Playoffs":
-			services.BroadcastActiveMatch(
-				matchLevel,
-				matchID,
-				"",
-				"",
-				db,
-			)
+		if err := activateMatch(db, notifier, matchLevel, match); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
 		}
 
 		c.Redirect(http.StatusSeeOther, "/admin")
@@ -115,6 +120,112 @@ func SetActiveMatchHandler(db *gorm.DB, dg *discordgo.Session) gin.HandlerFunc {
 	}
 }
 
+// activateMatch stages a match on the field, shows it on the overlays and
+// tells its players in Discord
+func activateMatch(db *gorm.DB, notifier services.Notifier, matchLevel string, match models.QualsMatch) error {
+	if err := services.Field.Stage(matchLevel, match.ID); err != nil {
+		return err
+	}
+
+	switch matchLevel {
+	case "Quals":
+		// Broadcast the active match update to all WebSocket clients
+		services.BroadcastActiveMatch(
+			matchLevel,
+			match.ID,
+			strconv.Itoa(match.RedPlayerID),
+			strconv.Itoa(match.BluePlayerID),
+			db,
+		)
+
+		services.AnnounceMatchCall(notifier, db, matchLevel, match)
+
+		if onDeck := services.Queue.Activate(db, match.ID); onDeck != nil {
+			services.AnnounceOnDeck(notifier, db, *onDeck)
+		}
+	case "Playoffs":
+		services.BroadcastActiveMatch(
+			matchLevel,
+			match.ID,
+			"",
+			"",
+			db,
+		)
+	}
+
+	if current, ok := services.CurrentMatch(); ok {
+		services.FireWebhook(db, services.WebhookMatchActivated, current)
+	}
+	return nil
+}
+
+// PlayoffResultHandler announces which alliance advanced from a playoff match
+func PlayoffResultHandler(db *gorm.DB, notifier services.Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		match := c.PostForm("match")
+		if match == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing playoff match"})
+			return
+		}
+
+		var numbers [4]int
+		for i, name := range []string{"redAlliance", "blueAlliance", "redScore", "blueScore"} {
+			value, err := strconv.Atoi(c.PostForm(name))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+				return
+			}
+			numbers[i] = value
+		}
+		redAlliance, blueAlliance, redScore, blueScore := numbers[0], numbers[1], numbers[2], numbers[3]
+		if redScore == blueScore {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Playoff matches can't end in a tie"})
+			return
+		}
+
+		advance := services.AdvanceAnnouncement{
+			Match:       match,
+			Winner:      redAlliance,
+			Loser:       blueAlliance,
+			WinnerScore: redScore,
+			LoserScore:  blueScore,
+		}
+		if blueScore > redScore {
+			advance.Winner, advance.Loser = blueAlliance, redAlliance
+			advance.WinnerScore, advance.LoserScore = blueScore, redScore
+		}
+		// Saving the same match again corrects its result
+		playoffMatch := models.PlayoffMatch{
+			Name:         match,
+			RedAlliance:  redAlliance,
+			BlueAlliance: blueAlliance,
+			RedScore:     redScore,
+			BlueScore:    blueScore,
+		}
+		if err := db.Where(models.PlayoffMatch{Name: match}).Assign(playoffMatch).FirstOrCreate(&playoffMatch).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save playoff result"})
+			return
+		}
+
+		services.AnnouncePlayoffAdvance(notifier, db, advance)
+		services.FireWebhook(db, services.WebhookBracketAdvanced, models.WebhookAdvancePayload(advance))
+		c.JSON(http.StatusOK, gin.H{"message": "Alliance " + strconv.Itoa(advance.Winner) + " advances"})
+	}
+}
+
+func SetQueueDepthHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		depth, err := strconv.Atoi(c.Query("depth"))
+		if err != nil || depth < 1 {
+			c.JSON(400, gin.H{"error": "Invalid queue depth"})
+			return
+		}
+
+		services.Queue.SetDepth(db, depth)
+		c.JSON(200, gin.H{"message": "Queue depth updated", "depth": depth})
+	}
+}
+
 func ShowEndgameScreenHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		matchIDStr := c.Param("id")
@@ -140,10 +251,12 @@ func ShowEndgameScreenHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		services.EndScreenBroadcast(
-			[]string{redUser.PreferedUsername},
-			[]string{blueUser.PreferedUsername},
-		)
+		services.EndScreenBroadcast(models.WebSocketMatchSavedPayload{
+			RedAlliance:   []string{redUser.PreferedUsername},
+			BlueAlliance:  []string{blueUser.PreferedUsername},
+			RedSurrogate:  match.RedSurrogate,
+			BlueSurrogate: match.BlueSurrogate,
+		})
 		c.Redirect(http.StatusSeeOther, "/admin")
 	}
 }
@@ -175,75 +288,150 @@ func SetEventNameHandler(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// GenerateMatchesHandler runs MatchMaker for numberofmatches rounds. With
+// preview set it only replies with the new matches and a token; posting the
+// token back saves that same draw. Append adds the matches after the
+// existing ones, and force replaces a schedule that has played matches.
 func GenerateMatchesHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var userCount int64
-		if err := db.Model(&models.User{}).Count(&userCount).Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to count users"})
-			return
+		options := services.GenerateOptions{
+			Append:  c.PostForm("append") != "",
+			Force:   c.PostForm("force") != "",
+			Preview: c.PostForm("preview") != "",
 		}
 
-		userCountStr := strconv.FormatInt(userCount, 10)
-		numberofmatches := c.Query("numberofmatches")
-		if numberofmatches == "" {
-			numberofmatches = "1"
+		// Committing a preview saves the draw that was previewed
+		token := c.PostForm("token")
+		var draft services.ScheduleDraft
+		if token != "" {
+			found, ok := services.FindDraft(token)
+			if !ok {
+				c.JSON(410, gin.H{"error": "The preview has expired, preview the schedule again"})
+				return
+			}
+			draft = found
+			options.Append = draft.Append
+		} else {
+			generated, output, ok := runMatchMaker(c, db)
+			if !ok {
+				return
+			}
+			draft = services.ScheduleDraft{Generated: generated, Output: output, Append: options.Append}
 		}
 
-		numberofmatchesInt, err := strconv.Atoi(numberofmatches)
+		preview, err := services.GenerateSchedule(db, draft.Generated, options)
 		if err != nil {
-			c.JSON(400, gin.H{"error": "Invalid number of matches"})
+			c.JSON(500, gin.H{"error": "Failed to save match schedule, the old schedule was kept", "details": err.Error()})
 			return
 		}
-
-		totalMatches := numberofmatchesInt * int(userCount)
-		if totalMatches == 0 {
-			c.JSON(400, gin.H{"error": "Invalid number of matches"})
+		if options.Preview {
+			if token, err = services.KeepDraft(draft); err != nil {
+				c.JSON(500, gin.H{"error": "Failed to keep the preview", "details": err.Error()})
+				return
+			}
+		}
+		if len(preview.Conflicts) > 0 {
+			c.JSON(409, gin.H{"error": "Schedule wasn't changed", "matches": preview.Matches, "conflicts": preview.Conflicts, "report": preview.Report, "token": token})
+			return
+		}
+		if options.Preview {
+			c.JSON(200, gin.H{"message": "Preview only, nothing was changed", "matches": preview.Matches, "report": preview.Report, "token": token})
 			return
 		}
+		if token != "" {
+			services.DiscardDraft(token)
+		}
 
-		cwd, err := os.Getwd()
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to get current working directory", "details": err.Error()})
+		os.WriteFile("match_schedule.txt", draft.Output, 0644)
+		if err := scheduleChanged(db, !options.Append); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to time match schedule", "details": err.Error()})
 			return
 		}
 
-		var matchMakerExePath string
-		switch runtime.GOOS {
-		case "windows":
-			matchMakerExePath = "data/MatchMaker.exe"
-		default:
-			matchMakerExePath = "./data/MatchMaker"
+		message := "Match schedule generated"
+		if options.Append {
+			message = "Added " + strconv.Itoa(len(preview.Matches)) + " matches"
 		}
+		c.JSON(200, gin.H{"message": message, "output": string(draft.Output), "matches": preview.Matches, "report": preview.Report})
+	}
+}
 
-		cmd := exec.Command(matchMakerExePath, "-t", userCountStr, "-r", numberofmatches, "-a", "2", "-s")
-		cmd.Env = os.Environ()
-		cmd.Dir = cwd
+// runMatchMaker draws the given number of rounds for every player. On
+// failure it has already replied.
+func runMatchMaker(c *gin.Context, db *gorm.DB) ([]services.GeneratedMatch, []byte, bool) {
+	var userCount int64
+	if err := db.Model(&models.User{}).Count(&userCount).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to count users"})
+		return nil, nil, false
+	}
 
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			c.JSON(500, gin.H{
-				"error":    "Failed to run MatchMaker.exe",
-				"details":  err.Error(),
-				"output":   string(output),
-				"path":     matchMakerExePath,
-				"cwd_used": cwd,
-			})
-			return
-		}
+	userCountStr := strconv.FormatInt(userCount, 10)
+	numberofmatches := c.PostForm("numberofmatches")
+	if numberofmatches == "" {
+		numberofmatches = "1"
+	}
 
-		os.WriteFile("match_schedule.txt", output, 0644)
+	numberofmatchesInt, err := strconv.Atoi(numberofmatches)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid number of matches"})
+		return nil, nil, false
+	}
 
-		if err := db.Where("1 = 1").Delete(&models.QualsMatch{}).Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to clear existing matches", "details": err.Error()})
-			return
-		}
+	totalMatches := numberofmatchesInt * int(userCount)
+	if totalMatches == 0 {
+		c.JSON(400, gin.H{"error": "Invalid number of matches"})
+		return nil, nil, false
+	}
 
-		if err := db.Exec("UPDATE sqlite_sequence SET seq = 0 WHERE name = 'quals_matches'").Error; err != nil {
-			c.JSON(500, gin.H{"error": "Failed to reset match sequence", "details": err.Error()})
-			return
-		}
+	cwd, err := os.Getwd()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get current working directory", "details": err.Error()})
+		return nil, nil, false
+	}
+
+	var matchMakerExePath string
+	switch runtime.GOOS {
+	case "windows":
+		matchMakerExePath = "data/MatchMaker.exe"
+	default:
+		matchMakerExePath = "./data/MatchMaker"
+	}
+
+	cmd := exec.Command(matchMakerExePath, "-t", userCountStr, "-r", numberofmatches, "-a", "2", "-s")
+	cmd.Env = os.Environ()
+	cmd.Dir = cwd
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error":    "Failed to run MatchMaker.exe",
+			"details":  err.Error(),
+			"output":   string(output),
+			"path":     matchMakerExePath,
+			"cwd_used": cwd,
+		})
+		return nil, nil, false
+	}
 
-		services.MigrateMatchSchedule()
-		c.JSON(200, gin.H{"message": "Match schedule generated", "output": string(output)})
+	generated, err := services.ParseMatchMakerOutput(output)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to read MatchMaker output", "details": err.Error(), "output": string(output)})
+		return nil, nil, false
+	}
+	return generated, output, true
+}
+
+// scheduleChanged times the new qualification matches and tells webhooks
+// about them. A replaced schedule also restarts the queue.
+func scheduleChanged(db *gorm.DB, replaced bool) error {
+	if err := services.ApplySchedule(db); err != nil {
+		return err
+	}
+	if replaced {
+		services.Queue.Reset(db)
+	} else {
+		services.Queue.Broadcast(db)
 	}
+	services.FireWebhook(db, services.WebhookScheduleGenerated, services.ScheduleWebhookPayload(db))
+	return nil
 }
@@ -7,7 +7,7 @@ import (
 	"gorm.io/gorm"
 )
 
-func AllianceSelectionHandler(db *gorm.DB) gin.HandlerFunc {
+func AllianceSelectionHandler(db *gorm.DB, notifier services.Notifier, guild services.GuildManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Handle GET request - render the page
 		if c.Request.Method == "GET" {
@@ -37,6 +37,10 @@ func AllianceSelectionHandler(db *gorm.DB) gin.HandlerFunc {
 				return
 			}
 
+			// Only announce picks that changed, not the same form saved again
+			var previous models.AllianceSelection
+			db.Where(models.AllianceSelection{AllianceNumber: request.Alliance}).Limit(1).Find(&previous)
+
 			// Upsert alliance selection (update if exists, else create)
 			allianceSelection := models.AllianceSelection{
 				AllianceNumber:    request.Alliance,
@@ -50,6 +54,15 @@ func AllianceSelectionHandler(db *gorm.DB) gin.HandlerFunc {
 				AllianceCaptain:   request.Captain,
 				AllianceSelection: request.Selection,
 			})
+			if request.Selection != "" && request.Selection != previous.AllianceSelection {
+				services.AnnounceAlliancePick(notifier, db, allianceSelection)
+				services.FireWebhook(db, services.WebhookAlliancePick, models.WebSocketAllianceSelectionPayload{
+					AllianceNumber:    allianceSelection.AllianceNumber,
+					AllianceCaptain:   allianceSelection.AllianceCaptain,
+					AllianceSelection: allianceSelection.AllianceSelection,
+				})
+			}
+			services.AutomateAllianceSelection(db, guild, allianceSelection, previous)
 
 			c.JSON(200, gin.H{
 				"message": "Alliance selection created successfully",
@@ -9,11 +9,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
-	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
 	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
 )
 
-func RegisterHandler(db *gorm.DB) gin.HandlerFunc {
+func RegisterHandler(db *gorm.DB, guild services.GuildManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		access_token := c.Query("access_token")
 		preferred_name := c.Query("preferred_name") // Get preferred name from query parameter
@@ -89,22 +88,25 @@ func RegisterHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		if db.First(&models.User{ID: idInt}).RowsAffected > 0 {
+		username, _ := userInfo["username"].(string)
+
+		user, created, err := services.RegisterUser(db, idInt, username, preferred_name)
+		if err != nil {
+			c.HTML(500, "authRedirect.tmpl", gin.H{
+				"error":       "Failed to register",
+				"ClientID":    os.Getenv("DISCORD_CLIENT_ID"),
+				"RedirectURI": os.Getenv("DISCORD_REDIRECT_URI"),
+			})
+			return
+		}
+		if !created {
 			c.JSON(200, gin.H{
 				"message": "User already registered",
 			})
 			return
 		}
-
-		username, _ := userInfo["username"].(string)
-
-		db.Create(&models.User{
-			ID:               idInt,
-			Username:         username,
-			PreferedUsername: preferred_name, // Set the preferred username
-			MMID:             services.CurrentMMID,
-		})
-		services.CurrentMMID++
+		services.AutomateRegistration(db, guild, user)
+		services.FireWebhook(db, services.WebhookUserRegistered, services.UserWebhookPayload(user))
 
 		c.Redirect(http.StatusSeeOther, "/")
 	}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"os"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/config"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/repository"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
+)
+
+// forEachDatabase runs test against a freshly migrated SQLite database, and
+// against Postgres too when TEST_POSTGRES_DSN is set. The Postgres database
+// is rolled all the way back afterwards, so point it at a scratch database.
+func forEachDatabase(t *testing.T, test func(t *testing.T, db *gorm.DB)) {
+	t.Run(config.DriverSQLite, func(t *testing.T) {
+		test(t, openTestDB(t, config.DriverSQLite, t.TempDir()+"/event.db"))
+	})
+	t.Run(config.DriverPostgres, func(t *testing.T) {
+		dsn := os.Getenv("TEST_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("TEST_POSTGRES_DSN is not set")
+		}
+		db := openTestDB(t, config.DriverPostgres, dsn)
+		t.Cleanup(func() {
+			if err := config.MigrateDown(db, len(config.Migrations), true); err != nil {
+				t.Errorf("rolling back: %v", err)
+			}
+		})
+		test(t, db)
+	})
+}
+
+// openTestDB migrates a database and resets the event state the services
+// keep in memory, so each test starts from an empty event
+func openTestDB(t *testing.T, driver string, dsn string) *gorm.DB {
+	t.Helper()
+	db, err := config.OpenDB(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	t.Cleanup(func() { config.CloseDB(db) })
+	if err := config.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	services.GetMMID(db)
+	services.InvalidateRankings()
+	services.Queue.Reset(db)
+	services.Field.Reset()
+	return db
+}
+
+// registerPlayers signs up players in order, so the first gets MMID 1
+func registerPlayers(t *testing.T, db *gorm.DB, names ...string) {
+	t.Helper()
+	for i, name := range names {
+		if _, _, err := services.RegisterUser(db, 1000+i, name, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// importSchedule saves matches given as red and blue MMID pairs
+func importSchedule(t *testing.T, db *gorm.DB, pairs ...[2]string) {
+	t.Helper()
+	matches := make([]services.ImportedMatch, len(pairs))
+	for i, pair := range pairs {
+		matches[i] = services.ImportedMatch{Match: i + 1, Red: services.PlayerRef(pair[0]), Blue: services.PlayerRef(pair[1])}
+	}
+	preview, err := services.ImportSchedule(db, matches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Conflicts) > 0 {
+		t.Fatalf("schedule conflicts: %+v", preview.Conflicts)
+	}
+}
+
+func TestEventOnEveryDatabase(t *testing.T) {
+	forEachDatabase(t, func(t *testing.T, db *gorm.DB) {
+		registerPlayers(t, db, "alpha", "bravo", "charlie", "delta")
+		importSchedule(t, db, [2]string{"1", "2"}, [2]string{"3", "4"}, [2]string{"2", "3"})
+
+		scores := []MatchScores{
+			{RedPlayerID: 1, BluePlayerID: 2, RedScore: 10, BlueScore: 5, RedAutoScore: 2, RedBonusRP: 1},
+			{RedPlayerID: 3, BluePlayerID: 4, RedScore: 4, BlueScore: 4, BlueBonusRP: 1},
+		}
+		for i, score := range scores {
+			var match models.QualsMatch
+			if err := db.First(&match, i+1).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := commitMatchScores(db, services.NoopNotifier{}, match, score); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var match models.QualsMatch
+		if err := db.First(&match, 1).Error; err != nil {
+			t.Fatal(err)
+		}
+		if match.RedTeleopScore != 8 || match.RedWinRP != 3 || match.CommittedAt == nil {
+			t.Errorf("match 1 saved as teleop %d, win RP %d, committed %v", match.RedTeleopScore, match.RedWinRP, match.CommittedAt)
+		}
+
+		rankings, err := repository.Rankings(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A tie earns no win RP, so only delta's bonus counts from match 2
+		want := []struct{ mmid, rp int }{{1, 4}, {4, 1}, {2, 0}, {3, 0}}
+		if len(rankings) != len(want) {
+			t.Fatalf("got %d ranked players, want %d", len(rankings), len(want))
+		}
+		for i, w := range want {
+			if rankings[i].MMID != w.mmid || rankings[i].TotalRP != w.rp {
+				t.Errorf("rank %d is MMID %d with %d RP, want MMID %d with %d RP", i+1, rankings[i].MMID, rankings[i].TotalRP, w.mmid, w.rp)
+			}
+		}
+	})
+}
@@ -0,0 +1,554 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
+)
+
+// discordCommand is a slash command and the handler that builds its reply
+type discordCommand struct {
+	definition *discordgo.ApplicationCommand
+	staffOnly  bool // Needs one of the roles in DISCORD_STAFF_ROLE_IDS
+	ephemeral  bool // Only the person who ran the command sees the reply
+	handler    func(db *gorm.DB, notifier services.Notifier, guild services.GuildManager, i *discordgo.InteractionCreate) string
+}
+
+// discordMessageLimit is the longest message Discord accepts
+const discordMessageLimit = 2000
+
+var (
+	minMatchID  = 1.0
+	minScore    = 0.0
+	matchOption = &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionInteger,
+		Name:        "match",
+		Description: "Match number",
+		Required:    true,
+		MinValue:    &minMatchID,
+	}
+)
+
+func scoreOption(name string, description string) *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type:        discordgo.ApplicationCommandOptionInteger,
+		Name:        name,
+		Description: description,
+		MinValue:    &minScore,
+	}
+}
+
+var discordCommands = []discordCommand{
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "register",
+			Description: "Register for the event",
+			Options: []*discordgo.ApplicationCommandOption{{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "name",
+				Description: "Name to show on the schedule and stream",
+				Required:    true,
+				MaxLength:   32,
+			}},
+		},
+		ephemeral: true,
+		handler:   registerCommand,
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "schedule",
+			Description: "Show your qualification matches",
+		},
+		ephemeral: true,
+		handler:   scheduleCommand,
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "nextmatch",
+			Description: "Show your next match and when it should start",
+		},
+		ephemeral: true,
+		handler:   nextMatchCommand,
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "rank",
+			Description: "Show your current ranking",
+		},
+		ephemeral: true,
+		handler:   rankCommand,
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "results",
+			Description: "Show the scores of a qualification match",
+			Options:     []*discordgo.ApplicationCommandOption{matchOption},
+		},
+		handler: resultsCommand,
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "activate",
+			Description: "Staff: put a match on the field",
+			Options: []*discordgo.ApplicationCommandOption{
+				matchOption,
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "level",
+					Description: "Match level, Quals by default",
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "Quals", Value: "Quals"},
+						{Name: "Playoffs", Value: "Playoffs"},
+					},
+				},
+			},
+		},
+		staffOnly: true,
+		ephemeral: true,
+		handler:   activateCommand,
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "score",
+			Description: "Staff: commit the scores of a qualification match",
+			Options: []*discordgo.ApplicationCommandOption{
+				matchOption,
+				withRequired(scoreOption("red", "Red total score")),
+				withRequired(scoreOption("blue", "Blue total score")),
+				scoreOption("red_auto", "Red auto score"),
+				scoreOption("blue_auto", "Blue auto score"),
+				scoreOption("red_endgame", "Red endgame score"),
+				scoreOption("blue_endgame", "Blue endgame score"),
+				scoreOption("red_bonus_rp", "Red bonus RP"),
+				scoreOption("blue_bonus_rp", "Blue bonus RP"),
+			},
+		},
+		staffOnly: true,
+		ephemeral: true,
+		handler:   scoreCommand,
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "leaderboard",
+			Description: "Staff: leaderboard controls",
+			Options: []*discordgo.ApplicationCommandOption{{
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Name:        "toggle",
+				Description: "Show or hide the leaderboard on the stream",
+			}},
+		},
+		staffOnly: true,
+		ephemeral: true,
+		handler:   leaderboardCommand,
+	},
+}
+
+func withRequired(option *discordgo.ApplicationCommandOption) *discordgo.ApplicationCommandOption {
+	option.Required = true
+	return option
+}
+
+// SetupDiscordCommands registers the slash commands, in DISCORD_GUILD_ID if
+// set or globally otherwise, and answers them
+func SetupDiscordCommands(dg *discordgo.Session, db *gorm.DB, notifier services.Notifier, guild services.GuildManager) {
+	commands := make(map[string]discordCommand, len(discordCommands))
+	definitions := make([]*discordgo.ApplicationCommand, 0, len(discordCommands))
+	for _, command := range discordCommands {
+		commands[command.definition.Name] = command
+		definitions = append(definitions, command.definition)
+	}
+
+	dg.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+		if i.Type != discordgo.InteractionApplicationCommand {
+			return
+		}
+		command, ok := commands[i.ApplicationCommandData().Name]
+		if !ok {
+			return
+		}
+
+		// Discord gives up on an interaction after 3 seconds, and commands
+		// like /score take longer, so acknowledge it first and reply after
+		deferred := &discordgo.InteractionResponseData{}
+		if command.ephemeral {
+			deferred.Flags = discordgo.MessageFlagsEphemeral
+		}
+		if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: deferred,
+		}); err != nil {
+			log.Printf("Error acknowledging /%s: %v", command.definition.Name, err)
+			return
+		}
+
+		reply := "Only event staff can use this command."
+		if !command.staffOnly || isStaff(i) {
+			reply = command.handler(db, notifier, guild, i)
+		}
+		if runes := []rune(reply); len(runes) > discordMessageLimit {
+			reply = string(runes[:discordMessageLimit-1]) + "…"
+		}
+
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &reply}); err != nil {
+			log.Printf("Error answering /%s: %v", command.definition.Name, err)
+		}
+	})
+
+	if _, err := dg.ApplicationCommandBulkOverwrite(dg.State.User.ID, os.Getenv("DISCORD_GUILD_ID"), definitions); err != nil {
+		log.Printf("Error registering Discord commands: %v", err)
+	}
+}
+
+// isStaff reports whether the member running a command has a staff role.
+// Commands sent in DMs have no roles.
+func isStaff(i *discordgo.InteractionCreate) bool {
+	if i.Member == nil {
+		return false
+	}
+	for _, staffRole := range services.StaffRoleIDs() {
+		for _, role := range i.Member.Roles {
+			if role == staffRole {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// interactionUser is whoever ran the command, in a server or a DM
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// registeredUser finds the player who ran the command
+func registeredUser(db *gorm.DB, i *discordgo.InteractionCreate) (models.User, bool) {
+	id, err := strconv.Atoi(interactionUser(i).ID)
+	if err != nil {
+		return models.User{}, false
+	}
+	var user models.User
+	if err := db.First(&user, id).Error; err != nil {
+		return models.User{}, false
+	}
+	return user, true
+}
+
+func commandOptions(i *discordgo.InteractionCreate) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range i.ApplicationCommandData().Options {
+		options[option.Name] = option
+	}
+	return options
+}
+
+// intOption reads an optional integer option, falling back to 0
+func intOption(options map[string]*discordgo.ApplicationCommandInteractionDataOption, name string) int {
+	if option, ok := options[name]; ok {
+		return int(option.IntValue())
+	}
+	return 0
+}
+
+// discordTime renders a time that Discord shows in each reader's time zone
+func discordTime(estimate services.MatchEstimate) string {
+	unix := estimate.EstimatedStart.Unix()
+	return fmt.Sprintf("<t:%d:t> (<t:%d:R>)", unix, unix)
+}
+
+func registerCommand(db *gorm.DB, notifier services.Notifier, guild services.GuildManager, i *discordgo.InteractionCreate) string {
+	if GetSchedulePublic() {
+		return "Registration is closed now that the schedule is out."
+	}
+
+	discordUser := interactionUser(i)
+	id, err := strconv.Atoi(discordUser.ID)
+	if err != nil {
+		return "Couldn't read your Discord ID."
+	}
+
+	name := strings.TrimSpace(commandOptions(i)["name"].StringValue())
+	user, created, err := services.RegisterUser(db, id, discordUser.Username, name)
+	if err != nil {
+		return "Couldn't register you. That name may already be taken."
+	}
+	if !created {
+		return "You're already registered as " + services.DisplayName(user) + "."
+	}
+	services.AutomateRegistration(db, guild, user)
+	services.FireWebhook(db, services.WebhookUserRegistered, services.UserWebhookPayload(user))
+	return "You're registered as " + services.DisplayName(user) + "! Your MatchMaker ID is " + strconv.Itoa(user.MMID) + "."
+}
+
+func scheduleCommand(db *gorm.DB, notifier services.Notifier, guild services.GuildManager, i *discordgo.InteractionCreate) string {
+	if !GetSchedulePublic() {
+		return "The schedule isn't public yet."
+	}
+	user, ok := registeredUser(db, i)
+	if !ok {
+		return "You aren't registered. Use /register first."
+	}
+
+	matches, err := services.GetUserMatches(db, user.MMID)
+	if err != nil {
+		return "Couldn't load your matches."
+	}
+	if len(matches) == 0 {
+		return "You don't have any matches yet."
+	}
+	estimates, err := services.EstimatesForPlayer(db, user.MMID)
+	if err != nil {
+		return "Couldn't load your matches."
+	}
+
+	lines := []string{"**Your matches**"}
+	for index, match := range matches {
+		line := describeMatch(db, match, user.MMID)
+		if match.CommittedAt == nil && !estimates[index].EstimatedStart.IsZero() {
+			line += " — " + discordTime(estimates[index])
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func nextMatchCommand(db *gorm.DB, notifier services.Notifier, guild services.GuildManager, i *discordgo.InteractionCreate) string {
+	if !GetSchedulePublic() {
+		return "The schedule isn't public yet."
+	}
+	user, ok := registeredUser(db, i)
+	if !ok {
+		return "You aren't registered. Use /register first."
+	}
+
+	matches, err := services.GetUserMatches(db, user.MMID)
+	if err != nil {
+		return "Couldn't load your matches."
+	}
+	estimates, err := services.EstimatesForPlayer(db, user.MMID)
+	if err != nil {
+		return "Couldn't load your matches."
+	}
+
+	for index, match := range matches {
+		if match.CommittedAt != nil {
+			continue
+		}
+		reply := "Next up: " + describeMatch(db, match, user.MMID)
+		if !estimates[index].EstimatedStart.IsZero() {
+			reply += "\nEstimated start: " + discordTime(estimates[index])
+		}
+		return reply
+	}
+	return "You've played all your matches."
+}
+
+func rankCommand(db *gorm.DB, notifier services.Notifier, guild services.GuildManager, i *discordgo.InteractionCreate) string {
+	if !GetSchedulePublic() {
+		return "Rankings aren't public yet."
+	}
+	user, ok := registeredUser(db, i)
+	if !ok {
+		return "You aren't registered. Use /register first."
+	}
+
+	leaderboard, err := services.GetLeaderboard(db)
+	if err != nil {
+		return "Couldn't load the rankings."
+	}
+	for _, ranked := range leaderboard {
+		if ranked.MMID != user.MMID {
+			continue
+		}
+		return fmt.Sprintf("You're ranked **#%d** of %d with %d RP (%d win, %d bonus) and %d points.",
+			ranked.Rank, len(leaderboard), ranked.TotalRP, ranked.WinRP, ranked.BonusRP, ranked.TotalPoints)
+	}
+	return "You aren't on the leaderboard yet."
+}
+
+func resultsCommand(db *gorm.DB, notifier services.Notifier, guild services.GuildManager, i *discordgo.InteractionCreate) string {
+	if !GetSchedulePublic() {
+		return "Match results aren't public yet."
+	}
+
+	matchID := intOption(commandOptions(i), "match")
+	var match models.QualsMatch
+	if err := db.First(&match, matchID).Error; err != nil {
+		return "Match not found."
+	}
+	if match.CommittedAt == nil {
+		return "Quals " + strconv.Itoa(match.ID) + " hasn't been scored yet."
+	}
+
+	red, blue := matchPlayers(db, match)
+	return fmt.Sprintf("**Quals %d**\n🔴 %s: %d (auto %d, teleop %d, endgame %d, +%d bonus RP)\n🔵 %s: %d (auto %d, teleop %d, endgame %d, +%d bonus RP)",
+		match.ID,
+		red, match.RedScore, match.RedAutoScore, match.RedTeleopScore, match.RedEndgameScore, match.RedBonusRP,
+		blue, match.BlueScore, match.BlueAutoScore, match.BlueTeleopScore, match.BlueEndgameScore, match.BlueBonusRP)
+}
+
+func activateCommand(db *gorm.DB, notifier services.Notifier, guild services.GuildManager, i *discordgo.InteractionCreate) string {
+	options := commandOptions(i)
+	level := "Quals"
+	if option, ok := options["level"]; ok {
+		level = option.StringValue()
+	}
+
+	var match models.QualsMatch
+	if err := db.First(&match, intOption(options, "match")).Error; err != nil {
+		return "Match not found."
+	}
+	if err := activateMatch(db, notifier, level, match); err != nil {
+		return "Couldn't activate the match: " + err.Error()
+	}
+	return level + " " + strconv.Itoa(match.ID) + " is now on the field."
+}
+
+func scoreCommand(db *gorm.DB, notifier services.Notifier, guild services.GuildManager, i *discordgo.InteractionCreate) string {
+	options := commandOptions(i)
+	var match models.QualsMatch
+	if err := db.First(&match, intOption(options, "match")).Error; err != nil {
+		return "Match not found."
+	}
+
+	scores := MatchScores{
+		RedPlayerID:      match.RedPlayerID,
+		BluePlayerID:     match.BluePlayerID,
+		RedScore:         intOption(options, "red"),
+		BlueScore:        intOption(options, "blue"),
+		RedAutoScore:     intOption(options, "red_auto"),
+		BlueAutoScore:    intOption(options, "blue_auto"),
+		RedEndgameScore:  intOption(options, "red_endgame"),
+		BlueEndgameScore: intOption(options, "blue_endgame"),
+		RedBonusRP:       intOption(options, "red_bonus_rp"),
+		BlueBonusRP:      intOption(options, "blue_bonus_rp"),
+	}
+	if scores.RedAutoScore+scores.RedEndgameScore > scores.RedScore || scores.BlueAutoScore+scores.BlueEndgameScore > scores.BlueScore {
+		return "Auto and endgame scores can't add up to more than the total."
+	}
+	if err := commitMatchScores(db, notifier, match, scores); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Quals %d saved: 🔴 %d – %d 🔵", match.ID, scores.RedScore, scores.BlueScore)
+}
+
+func leaderboardCommand(db *gorm.DB, notifier services.Notifier, guild services.GuildManager, i *discordgo.InteractionCreate) string {
+	services.ToggleLeaderboardVisibility()
+	if services.GetLeaderboardVisibility() {
+		return "The leaderboard is now showing on stream."
+	}
+	return "The leaderboard is now hidden."
+}
+
+// matchPlayers looks up the display names of both players in a match,
+// marking surrogates
+func matchPlayers(db *gorm.DB, match models.QualsMatch) (string, string) {
+	var redUser, blueUser models.User
+	db.Where("mm_id = ?", match.RedPlayerID).First(&redUser)
+	db.Where("mm_id = ?", match.BluePlayerID).First(&blueUser)
+	red, blue := services.DisplayName(redUser), services.DisplayName(blueUser)
+	if match.RedSurrogate {
+		red += " (surrogate)"
+	}
+	if match.BlueSurrogate {
+		blue += " (surrogate)"
+	}
+	return red, blue
+}
+
+// describeMatch summarizes a match from one player's point of view
+func describeMatch(db *gorm.DB, match models.QualsMatch, mmid int) string {
+	red, blue := matchPlayers(db, match)
+	alliance, opponent, surrogate := "🔴", blue, match.RedSurrogate
+	if match.BluePlayerID == mmid {
+		alliance, opponent, surrogate = "🔵", red, match.BlueSurrogate
+	}
+
+	line := fmt.Sprintf("Quals %d %s vs %s", match.ID, alliance, opponent)
+	if surrogate {
+		line += " (surrogate, doesn't count towards your ranking)"
+	}
+	if match.CommittedAt != nil {
+		line += fmt.Sprintf(" — played, %d–%d", match.RedScore, match.BlueScore)
+	}
+	return line
+}
+
+// DiscordSettingsHandler saves the event's announcement channels and templates
+func DiscordSettingsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		settings := models.DiscordSettings{
+			MatchChannelID:    strings.TrimSpace(c.PostForm("matchChannel")),
+			ResultsChannelID:  strings.TrimSpace(c.PostForm("resultsChannel")),
+			RankingsChannelID: strings.TrimSpace(c.PostForm("rankingsChannel")),
+			AllianceChannelID: strings.TrimSpace(c.PostForm("allianceChannel")),
+			MatchCallTemplate: c.PostForm("matchCallTemplate"),
+			OnDeckTemplate:    c.PostForm("onDeckTemplate"),
+			ResultsTemplate:   c.PostForm("resultsTemplate"),
+			AllianceTemplate:  c.PostForm("allianceTemplate"),
+			AdvanceTemplate:   c.PostForm("advanceTemplate"),
+			ManageRoles:       c.PostForm("manageRoles") != "",
+			RolesDryRun:       c.PostForm("rolesDryRun") != "",
+		}
+
+		numbers := []struct {
+			name   string
+			target *int
+		}{
+			{"rankingsSize", &settings.RankingsSize},
+			{"rankingsEvery", &settings.RankingsEvery},
+		}
+		for _, number := range numbers {
+			value := c.PostForm(number.name)
+			if value == "" {
+				continue
+			}
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + number.name})
+				return
+			}
+			*number.target = parsed
+		}
+
+		if err := services.SaveDiscordSettings(db, settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Discord settings saved"})
+	}
+}
+
+// DiscordAutomationHandler runs one batch of role or channel changes. With
+// dryRun set it only replies with the actions it would take.
+func DiscordAutomationHandler(db *gorm.DB, guild services.GuildManager, action func(db *gorm.DB, guild services.GuildManager, dryRun bool) ([]string, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dryRun := c.PostForm("dryRun") != ""
+		actions, err := action(db, guild, dryRun)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "actions": actions})
+			return
+		}
+
+		message := "Discord updated"
+		if dryRun {
+			message = "Dry run, nothing was changed"
+		}
+		if len(actions) == 0 {
+			message = "Nothing to do"
+		}
+		c.JSON(http.StatusOK, gin.H{"message": message, "actions": actions})
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
+)
+
+// ExportHandler downloads one export as CSV or, with ?format=json, as JSON.
+// The public API only serves exports once the schedule is public.
+func ExportHandler(db *gorm.DB, public bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if public && !GetSchedulePublic() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Schedule is not public"})
+			return
+		}
+
+		name := c.Param("name")
+		build, ok := services.FindExport(name)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown export"})
+			return
+		}
+		table, err := build(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build " + name + " export"})
+			return
+		}
+
+		switch c.DefaultQuery("format", "csv") {
+		case "csv":
+			c.Header("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Status(http.StatusOK)
+			if err := table.WriteCSV(c.Writer); err != nil {
+				c.Error(err)
+			}
+		case "json":
+			c.Header("Content-Disposition", `attachment; filename="`+name+`.json"`)
+			c.JSON(http.StatusOK, table)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be csv or json"})
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
+)
+
+func FieldStatusHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, services.Field.Status())
+	}
+}
+
+// FieldControlHandler runs one field action such as Start or Pause and
+// replies with the resulting clock
+func FieldControlHandler(action func() error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := action(); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, services.Field.Status())
+	}
+}
+
+func ResetFieldHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		services.Field.Reset()
+		c.JSON(http.StatusOK, services.Field.Status())
+	}
+}
+
+func SetFieldTimingHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timing := services.Field.Timing()
+		fields := []struct {
+			name   string
+			target *time.Duration
+		}{
+			{"auto", &timing.Auto},
+			{"transition", &timing.Transition},
+			{"teleop", &timing.Teleop},
+			{"endgame", &timing.Endgame},
+		}
+
+		// Periods left out of the form keep their current length
+		for _, field := range fields {
+			value := c.PostForm(field.name)
+			if value == "" {
+				continue
+			}
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + field.name + " length"})
+				return
+			}
+			*field.target = time.Duration(seconds) * time.Second
+		}
+
+		if err := services.Field.SetTiming(timing); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, services.Field.Status())
+	}
+}
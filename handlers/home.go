@@ -17,9 +17,16 @@ func HomeHandler(db *gorm.DB) gin.HandlerFunc {
 				"isSchedulePublic": isSchedulePublic,
 			})
 		} else {
+			// The schedule still renders without estimates if they fail
+			estimate, _ := services.EstimateSchedule(db)
 			c.HTML(200, "index.tmpl", gin.H{
 				"title":            "ORC Dashboard",
-				"matches":          services.ParseMatchSchedule(),
+				"matches":          services.ParseMatchScheduleFromDB(db),
+				"queue":            services.Queue.Status(db),
+				"estimates":        estimate.ByMatch(),
+				"driftSeconds":     estimate.DriftSeconds,
+				"blocks":           services.BlockStarts(db),
+				"breaks":           services.BreaksAfter(db),
 				"isSchedulePublic": isSchedulePublic,
 			})
 		}
@@ -1,15 +1,19 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"strconv"
+	"time"
 
 	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/repository"
 	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func EditMatchesHandler(db *gorm.DB) gin.HandlerFunc {
+func EditMatchesHandler(db *gorm.DB, notifier services.Notifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the ID from the URL parameter
 		idParam := c.Param("id")
@@ -96,10 +100,6 @@ func EditMatchesHandler(db *gorm.DB) gin.HandlerFunc {
 				return
 			}
 
-			// Calculate teleop scores
-			redTeleopScoreInt := redTotalScoreInt - redAutoScoreInt - redEndgameScoreInt
-			blueTeleopScoreInt := blueTotalScoreInt - blueAutoScoreInt - blueEndgameScoreInt
-
 			redBonusRPInt, err := strconv.Atoi(redBonusRP)
 			if err != nil {
 				c.JSON(400, gin.H{"error": "Invalid red bonus RP"})
@@ -112,57 +112,135 @@ func EditMatchesHandler(db *gorm.DB) gin.HandlerFunc {
 				return
 			}
 
-			redWinRP, blueWinRP := 0, 0
-
-			if redTotalScoreInt > blueTotalScoreInt {
-				redWinRP = 3
-				blueWinRP = 0
-			} else if redTotalScoreInt < blueTotalScoreInt {
-				redWinRP = 0
-				blueWinRP = 3
-			} else {
-				redWinRP = 1
-				blueWinRP = 1
-			}
-			// Update the match
-			if err := db.Model(&match).Updates(models.QualsMatch{
+			scores := MatchScores{
 				RedPlayerID:      redID,
 				BluePlayerID:     blueID,
-				RedTeleopScore:   redTeleopScoreInt,
-				BlueTeleopScore:  blueTeleopScoreInt,
+				RedScore:         redTotalScoreInt,
+				BlueScore:        blueTotalScoreInt,
 				RedAutoScore:     redAutoScoreInt,
 				BlueAutoScore:    blueAutoScoreInt,
 				RedEndgameScore:  redEndgameScoreInt,
 				BlueEndgameScore: blueEndgameScoreInt,
-				RedScore:         redTotalScoreInt,
-				BlueScore:        blueTotalScoreInt,
-				RedWinRP:         redWinRP,
-				BlueWinRP:        blueWinRP,
 				RedBonusRP:       redBonusRPInt,
 				BlueBonusRP:      blueBonusRPInt,
-			}).Error; err != nil {
-				c.JSON(500, gin.H{"error": "Failed to update match"})
-				return
-			}
-
-			// Fetch usernames for broadcast
-			var redUser, blueUser models.User
-			if err := db.Where("mm_id = ?", redID).First(&redUser).Error; err != nil {
-				c.JSON(500, gin.H{"error": "Failed to fetch red user"})
-				return
 			}
-			if err := db.Where("mm_id = ?", blueID).First(&blueUser).Error; err != nil {
-				c.JSON(500, gin.H{"error": "Failed to fetch blue user"})
+			if err := commitMatchScores(db, notifier, match, scores); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
 				return
 			}
 
-			services.BroadcastLeaderboardUpdate(db)
-
 			c.Redirect(302, "/admin/")
 		}
 	}
 }
 
+// MatchScores is what staff enter when committing a qualification match.
+// Teleop scores and win RP are worked out from them.
+type MatchScores struct {
+	RedPlayerID      int
+	BluePlayerID     int
+	RedScore         int
+	BlueScore        int
+	RedAutoScore     int
+	BlueAutoScore    int
+	RedEndgameScore  int
+	BlueEndgameScore int
+	RedBonusRP       int
+	BlueBonusRP      int
+}
+
+// commitMatchScores saves a match's scores and moves the event on: the
+// leaderboard, the field's end screen, the queue and the schedule estimates
+func commitMatchScores(db *gorm.DB, notifier services.Notifier, match models.QualsMatch, scores MatchScores) error {
+	// Calculate teleop scores
+	redTeleopScore := scores.RedScore - scores.RedAutoScore - scores.RedEndgameScore
+	blueTeleopScore := scores.BlueScore - scores.BlueAutoScore - scores.BlueEndgameScore
+
+	redWinRP, blueWinRP := 0, 0
+
+	if scores.RedScore > scores.BlueScore {
+		redWinRP = 3
+		blueWinRP = 0
+	} else if scores.RedScore < scores.BlueScore {
+		redWinRP = 0
+		blueWinRP = 3
+	} else {
+		redWinRP = 1
+		blueWinRP = 1
+	}
+	// Only the first save counts towards schedule drift, not later corrections
+	firstCommit := match.CommittedAt == nil
+	committedAt := match.CommittedAt
+	if firstCommit {
+		now := time.Now()
+		committedAt = &now
+	}
+
+	// Update the match
+	if err := db.Model(&match).Updates(models.QualsMatch{
+		RedPlayerID:      scores.RedPlayerID,
+		BluePlayerID:     scores.BluePlayerID,
+		RedTeleopScore:   redTeleopScore,
+		BlueTeleopScore:  blueTeleopScore,
+		RedAutoScore:     scores.RedAutoScore,
+		BlueAutoScore:    scores.BlueAutoScore,
+		RedEndgameScore:  scores.RedEndgameScore,
+		BlueEndgameScore: scores.BlueEndgameScore,
+		RedScore:         scores.RedScore,
+		BlueScore:        scores.BlueScore,
+		RedWinRP:         redWinRP,
+		BlueWinRP:        blueWinRP,
+		RedBonusRP:       scores.RedBonusRP,
+		BlueBonusRP:      scores.BlueBonusRP,
+		CommittedAt:      committedAt,
+	}).Error; err != nil {
+		return errors.New("Failed to update match")
+	}
+	services.InvalidateRankings()
+	if err := services.RecordRankingSnapshot(db, match.ID, !firstCommit); err != nil {
+		log.Printf("Error recording rankings after match %d: %v", match.ID, err)
+	}
+
+	// Fetch usernames for broadcast
+	var redUser, blueUser models.User
+	if err := db.Where("mm_id = ?", scores.RedPlayerID).First(&redUser).Error; err != nil {
+		return errors.New("Failed to fetch red user")
+	}
+	if err := db.Where("mm_id = ?", scores.BluePlayerID).First(&blueUser).Error; err != nil {
+		return errors.New("Failed to fetch blue user")
+	}
+
+	services.BroadcastLeaderboardUpdate(db)
+	if leaderboard, err := services.GetLeaderboard(db); err == nil {
+		services.FireWebhook(db, services.WebhookRankingsChanged, models.WebSocketLeaderboardPayload{Users: leaderboard})
+	}
+
+	// Committing the match on the field shows its end screen
+	services.Field.PostScores(
+		"Quals",
+		match.ID,
+		models.WebSocketMatchSavedPayload{
+			RedAlliance:   []string{redUser.PreferedUsername},
+			BlueAlliance:  []string{blueUser.PreferedUsername},
+			RedSurrogate:  match.RedSurrogate,
+			BlueSurrogate: match.BlueSurrogate,
+		},
+	)
+	if onDeck := services.Queue.Commit(db, match.ID); onDeck != nil {
+		services.AnnounceOnDeck(notifier, db, *onDeck)
+	}
+	services.BroadcastScheduleUpdate(db)
+
+	if err := db.First(&match, match.ID).Error; err == nil {
+		services.AnnounceResults(notifier, db, match, !firstCommit)
+		services.FireWebhook(db, services.WebhookScoreCommitted, services.ScoreWebhookPayload(db, match, !firstCommit))
+	}
+	if firstCommit {
+		services.AnnounceRankingsAfter(notifier, db, match.ID)
+	}
+	return nil
+}
+
 // MatchWithNames represents a match with player names instead of IDs
 type MatchWithNames struct {
 	ID               int
@@ -180,12 +258,14 @@ type MatchWithNames struct {
 	BlueWinRP        int
 	RedBonusRP       int
 	BlueBonusRP      int
+	RedSurrogate     bool
+	BlueSurrogate    bool
 }
 
 func MatchResultsHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var matches []models.QualsMatch
-		if err := db.Find(&matches).Error; err != nil {
+		matches, err := repository.Matches(db)
+		if err != nil {
 			c.JSON(500, gin.H{"error": "Failed to fetch matches"})
 			return
 		}
@@ -193,16 +273,12 @@ func MatchResultsHandler(db *gorm.DB) gin.HandlerFunc {
 		// Convert matches to include player names
 		var matchesWithNames []MatchWithNames
 		for _, match := range matches {
-			var redUser, blueUser models.User
-
-			// Get red player name
-			if err := db.Where("mm_id = ?", match.RedPlayerID).First(&redUser).Error; err != nil {
+			redUser, blueUser := match.Red, match.Blue
+			if redUser.MMID == 0 {
 				c.JSON(500, gin.H{"error": "Failed to fetch red player"})
 				return
 			}
-
-			// Get blue player name
-			if err := db.Where("mm_id = ?", match.BluePlayerID).First(&blueUser).Error; err != nil {
+			if blueUser.MMID == 0 {
 				c.JSON(500, gin.H{"error": "Failed to fetch blue player"})
 				return
 			}
@@ -234,6 +310,8 @@ func MatchResultsHandler(db *gorm.DB) gin.HandlerFunc {
 				BlueWinRP:        match.BlueWinRP,
 				RedBonusRP:       match.RedBonusRP,
 				BlueBonusRP:      match.BlueBonusRP,
+				RedSurrogate:     match.RedSurrogate,
+				BlueSurrogate:    match.BlueSurrogate,
 			}
 			matchesWithNames = append(matchesWithNames, matchWithNames)
 		}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/config"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
+)
+
+func TestCommitMatchScoresAnnounces(t *testing.T) {
+	db := openTestDB(t, config.DriverSQLite, t.TempDir()+"/event.db")
+	registerPlayers(t, db, "alpha", "bravo", "charlie", "delta")
+	importSchedule(t, db, [2]string{"1", "2"}, [2]string{"3", "4"}, [2]string{"2", "3"}, [2]string{"4", "1"})
+	err := services.SaveDiscordSettings(db, models.DiscordSettings{
+		MatchChannelID:    "matches",
+		ResultsChannelID:  "results",
+		RankingsChannelID: "rankings",
+		RankingsEvery:     1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Quals 1 is on the field and Quals 2's players have already been pinged
+	services.Queue.Activate(db, 1)
+	notifier := &services.RecordingNotifier{}
+	commit := func(scores MatchScores) []services.SentMessage {
+		t.Helper()
+		notifier.Reset()
+		var match models.QualsMatch
+		if err := db.First(&match, 1).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := commitMatchScores(db, notifier, match, scores); err != nil {
+			t.Fatal(err)
+		}
+		return notifier.Sent()
+	}
+
+	sent := commit(MatchScores{RedPlayerID: 1, BluePlayerID: 2, RedScore: 10, BlueScore: 5, RedBonusRP: 1})
+	if len(sent) != 3 {
+		t.Fatalf("got %d announcements, want on deck, results and rankings: %+v", len(sent), sent)
+	}
+	onDeck, results, rankings := sent[0], sent[1], sent[2]
+
+	if onDeck.ChannelID != "matches" || onDeck.Content != "Quals 3 is on deck: <@1001> vs. <@1002>. Get ready!" {
+		t.Errorf("on deck posted %q to %q", onDeck.Content, onDeck.ChannelID)
+	}
+
+	if results.ChannelID != "results" || results.Embed == nil {
+		t.Fatalf("results posted %+v", results)
+	}
+	if results.Embed.Title != "Quals 1: alpha 10 – 5 bravo" {
+		t.Errorf("results title is %q", results.Embed.Title)
+	}
+	if red := results.Embed.Fields[0]; red.Name != "🔴 alpha" || !strings.HasSuffix(red.Value, "+4 RP") {
+		t.Errorf("red breakdown is %q: %q", red.Name, red.Value)
+	}
+
+	if rankings.ChannelID != "rankings" || rankings.Embed == nil {
+		t.Fatalf("rankings posted %+v", rankings)
+	}
+	if rankings.Embed.Title != "🏆 Rankings after Quals 1" {
+		t.Errorf("rankings title is %q", rankings.Embed.Title)
+	}
+	if first := strings.Split(rankings.Embed.Description, "\n")[0]; first != "**1.** alpha — 4 RP, 10 points" {
+		t.Errorf("rankings start with %q", first)
+	}
+
+	// A correction reposts the results, marked as such, and nothing else
+	sent = commit(MatchScores{RedPlayerID: 1, BluePlayerID: 2, RedScore: 10, BlueScore: 12})
+	if len(sent) != 1 || sent[0].Embed == nil || sent[0].ChannelID != "results" {
+		t.Fatalf("correction posted %+v", sent)
+	}
+	if sent[0].Embed.Title != "Quals 1: alpha 10 – 12 bravo (corrected)" {
+		t.Errorf("corrected results title is %q", sent[0].Embed.Title)
+	}
+}
@@ -3,19 +3,26 @@ package handlers
 import (
 	"os"
 
-	"github.com/bwmarrin/discordgo"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
 )
 
-func SetupRoutes(r *gin.Engine, db *gorm.DB, dg *discordgo.Session) {
+func SetupRoutes(r *gin.Engine, db *gorm.DB, notifier services.Notifier, guild services.GuildManager) {
 	// Public routes
 	r.GET("/", HomeHandler(db))
-	r.GET("/register", RegisterHandler(db))
-	r.GET("/auth", RegisterHandler(db))
+	r.GET("/register", RegisterHandler(db, guild))
+	r.GET("/auth", RegisterHandler(db, guild))
 	r.GET("/leaderboard", LeaderboardHandler(db))
 	r.GET("/matches", MatchResultsHandler(db))
+	r.GET("/players/:mmid", PlayerHandler(db))
+	r.GET("/api/schedule", ScheduleAPIHandler(db))
+	r.GET("/api/rankings/history", RankingHistoryHandler(db))
+	r.GET("/api/export/:name", ExportHandler(db, true))
 	r.GET("/ws", WebSocketHandler(db))
+	r.GET("/ws/protocol", WebSocketProtocolHandler())
+	r.GET("/events", EventsHandler())
 	r.GET("/overlay", OverlayHandler())
 
 	// Admin routes
@@ -24,17 +31,42 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, dg *discordgo.Session) {
 	}))
 
 	authorized.GET("/", AdminDashboardHandler(db))
+	authorized.GET("/ws", AdminWebSocketHandler(db))
 	authorized.GET("/users", AdminUsersHandler(db))
+	authorized.GET("/export/:name", ExportHandler(db, false))
 	authorized.POST("/toggle_schedule", ToggleScheduleHandler(db))
-	authorized.GET("/generate", GenerateMatchesHandler(db))
-	authorized.GET("/match/:id/edit", EditMatchesHandler(db))
-	authorized.POST("/match/:id/edit", EditMatchesHandler(db))
+	authorized.POST("/generate", GenerateMatchesHandler(db))
+	authorized.GET("/match/:id/edit", EditMatchesHandler(db, notifier))
+	authorized.POST("/match/:id/edit", EditMatchesHandler(db, notifier))
 	authorized.GET("/match/:id/endgame", ShowEndgameScreenHandler(db))
-	authorized.GET("/set_active_match", SetActiveMatchHandler(db, dg))
+	authorized.GET("/set_active_match", SetActiveMatchHandler(db, notifier))
+	authorized.POST("/playoff_result", PlayoffResultHandler(db, notifier))
 	authorized.GET("/set_event_name", SetEventNameHandler(db))
+	authorized.GET("/set_queue_depth", SetQueueDepthHandler(db))
+	authorized.POST("/schedule_timing", SetScheduleTimingHandler(db))
+	authorized.POST("/schedule/import", ImportScheduleHandler(db))
+	authorized.POST("/schedule/blocks", AddScheduleBlockHandler(db))
+	authorized.POST("/schedule/blocks/:id/delete", DeleteScheduleBlockHandler(db))
+	authorized.POST("/schedule/breaks", InsertBreakHandler(db))
+	authorized.POST("/schedule/breaks/:id/delete", DeleteBreakHandler(db))
 	authorized.GET("/toggle_leaderboard", ToggleLeaderboardVisibilityHandler(db))
-	authorized.GET("/allianceSelection", AllianceSelectionHandler(db))
-	authorized.POST("/allianceSelection", AllianceSelectionHandler(db))
+	authorized.GET("/allianceSelection", AllianceSelectionHandler(db, notifier, guild))
+	authorized.POST("/allianceSelection", AllianceSelectionHandler(db, notifier, guild))
 	authorized.POST("/toggle_alliance_selection", ToggleAllianceSelectionHandler(db))
 	authorized.POST("/reset_alliance_selections", ResetAllianceSelectionHandler(db))
+	authorized.POST("/discord_settings", DiscordSettingsHandler(db))
+	authorized.POST("/discord/sync_roles", DiscordAutomationHandler(db, guild, services.SyncEventRoles))
+	authorized.POST("/discord/playoff_channels", DiscordAutomationHandler(db, guild, services.CreatePlayoffChannels))
+	authorized.POST("/discord/archive", DiscordAutomationHandler(db, guild, services.ArchiveEventDiscord))
+	authorized.POST("/webhooks", AddWebhookHandler(db))
+	authorized.POST("/webhooks/:id/toggle", ToggleWebhookHandler(db))
+	authorized.POST("/webhooks/:id/delete", DeleteWebhookHandler(db))
+	authorized.POST("/webhooks/deliveries/:id/redeliver", RedeliverWebhookHandler(db))
+	authorized.GET("/field", FieldStatusHandler())
+	authorized.POST("/field/start", FieldControlHandler(services.Field.Start))
+	authorized.POST("/field/pause", FieldControlHandler(services.Field.Pause))
+	authorized.POST("/field/resume", FieldControlHandler(services.Field.Resume))
+	authorized.POST("/field/abort", FieldControlHandler(services.Field.Abort))
+	authorized.POST("/field/reset", ResetFieldHandler())
+	authorized.POST("/field/timing", SetFieldTimingHandler())
 }
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/repository"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
+)
+
+// ScheduleAPIHandler returns the estimated start of every qualification match
+func ScheduleAPIHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !GetSchedulePublic() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Schedule is not public"})
+			return
+		}
+
+		estimate, err := services.EstimateSchedule(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to estimate schedule"})
+			return
+		}
+		c.JSON(http.StatusOK, estimate)
+	}
+}
+
+// RankingHistoryHandler serves the rankings after every committed match, for
+// charting rank history. ?mmid= narrows it to one player.
+func RankingHistoryHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !GetSchedulePublic() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Rankings are not public"})
+			return
+		}
+
+		mmid := 0
+		if param := c.Query("mmid"); param != "" {
+			parsed, err := strconv.Atoi(param)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MMID"})
+				return
+			}
+			mmid = parsed
+		}
+
+		history, err := services.RankingHistory(db, mmid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load ranking history"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"snapshots": history})
+	}
+}
+
+// PlayerMatch is one of a player's matches as shown on their page
+type PlayerMatch struct {
+	ID           int
+	Alliance     string
+	OpponentName string
+	Surrogate    bool // The match doesn't count towards the player's ranking
+	Estimate     services.MatchEstimate
+}
+
+func PlayerHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !GetSchedulePublic() {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Schedule is not public"})
+			return
+		}
+
+		mmid, err := strconv.Atoi(c.Param("mmid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid player ID"})
+			return
+		}
+
+		var user models.User
+		if err := db.Where("mm_id = ?", mmid).First(&user).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Player not found"})
+			return
+		}
+
+		matches, err := services.GetUserMatches(db, mmid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch matches"})
+			return
+		}
+		estimate, err := services.EstimateSchedule(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to estimate schedule"})
+			return
+		}
+		byMatch := estimate.ByMatch()
+		players, err := repository.PlayersByMMID(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch players"})
+			return
+		}
+
+		playerMatches := make([]PlayerMatch, 0, len(matches))
+		for _, match := range matches {
+			alliance, opponentID, surrogate := "Red", match.BluePlayerID, match.RedSurrogate
+			if match.BluePlayerID == mmid {
+				alliance, opponentID, surrogate = "Blue", match.RedPlayerID, match.BlueSurrogate
+			}
+
+			opponentName := "Unknown Player"
+			if opponent, ok := players[opponentID]; ok {
+				opponentName = opponent.Username
+				if opponent.PreferedUsername != "" {
+					opponentName = opponent.PreferedUsername
+				}
+			}
+
+			playerMatches = append(playerMatches, PlayerMatch{
+				ID:           match.ID,
+				Alliance:     alliance,
+				OpponentName: opponentName,
+				Surrogate:    surrogate,
+				Estimate:     byMatch[match.ID],
+			})
+		}
+
+		name := user.Username
+		if user.PreferedUsername != "" {
+			name = user.PreferedUsername
+		}
+		c.HTML(http.StatusOK, "player.tmpl", gin.H{
+			"title":        name,
+			"player":       user,
+			"matches":      playerMatches,
+			"driftSeconds": estimate.DriftSeconds,
+		})
+	}
+}
+
+// SetScheduleTimingHandler sets the cycle time in minutes and the planned
+// start of the first match as an RFC 3339 time. Leaving the start empty
+// plans the schedule from the first committed match.
+func SetScheduleTimingHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cycleMinutes, err := strconv.ParseFloat(c.PostForm("cycle"), 64)
+		if err != nil || cycleMinutes <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cycle time"})
+			return
+		}
+
+		var start time.Time
+		if value := c.PostForm("start"); value != "" {
+			start, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start time"})
+				return
+			}
+		}
+
+		cycleTime := time.Duration(cycleMinutes * float64(time.Minute))
+		if err := services.SetScheduleTiming(db, cycleTime, start); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save schedule timing"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"cycleMinutes": cycleTime.Minutes(),
+			"start":        start,
+		})
+	}
+}
+
+// AddScheduleBlockHandler adds a time block from the form fields label, start
+// (RFC 3339), cycle (minutes) and matches (0 or empty for the rest)
+func AddScheduleBlockHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start, err := time.Parse(time.RFC3339, c.PostForm("start"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start time"})
+			return
+		}
+		cycleMinutes, err := strconv.ParseFloat(c.PostForm("cycle"), 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cycle time"})
+			return
+		}
+		matchCount := 0
+		if value := c.PostForm("matches"); value != "" {
+			if matchCount, err = strconv.Atoi(value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid match count"})
+				return
+			}
+		}
+
+		block := models.ScheduleBlock{
+			Label:        c.PostForm("label"),
+			Start:        start,
+			CycleSeconds: int(cycleMinutes * 60),
+			MatchCount:   matchCount,
+		}
+		if err := services.AddScheduleBlock(db, block); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Schedule block added"})
+	}
+}
+
+func DeleteScheduleBlockHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid block ID"})
+			return
+		}
+		if err := services.DeleteScheduleBlock(db, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete block"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Schedule block deleted"})
+	}
+}
+
+// InsertBreakHandler pauses the schedule after a match for the form fields
+// after (match ID), minutes and label
+func InsertBreakHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		afterMatchID, err := strconv.Atoi(c.PostForm("after"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid match ID"})
+			return
+		}
+		minutes, err := strconv.ParseFloat(c.PostForm("minutes"), 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid break length"})
+			return
+		}
+
+		duration := time.Duration(minutes * float64(time.Minute))
+		if err := services.InsertBreak(db, afterMatchID, duration, c.PostForm("label")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Break inserted"})
+	}
+}
+
+func DeleteBreakHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid break ID"})
+			return
+		}
+		if err := services.DeleteBreak(db, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete break"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Break deleted"})
+	}
+}
+
+// ImportScheduleHandler replaces the qualification schedule with an uploaded
+// CSV or JSON file. With preview set, or when the file has conflicts, it only
+// replies with the resolved matches and the conflicts.
+func ImportScheduleHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing schedule file"})
+			return
+		}
+		opened, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read schedule file"})
+			return
+		}
+		defer opened.Close()
+		data, err := io.ReadAll(opened)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read schedule file"})
+			return
+		}
+
+		format := c.PostForm("format")
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+		}
+		matches, err := services.ParseScheduleImport(data, format)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if c.PostForm("preview") != "" {
+			preview, err := services.PreviewScheduleImport(db, matches)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check schedule"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "Preview only, nothing was changed", "matches": preview.Matches, "conflicts": preview.Conflicts, "report": preview.Report})
+			return
+		}
+
+		imported, err := services.ImportSchedule(db, matches)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import schedule", "details": err.Error()})
+			return
+		}
+		if len(imported.Conflicts) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Schedule has conflicts, nothing was changed", "matches": imported.Matches, "conflicts": imported.Conflicts, "report": imported.Report})
+			return
+		}
+		if err := scheduleChanged(db, true); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to time match schedule", "details": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Imported " + strconv.Itoa(len(imported.Matches)) + " matches", "matches": imported.Matches, "report": imported.Report})
+	}
+}
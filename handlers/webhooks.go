@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
+)
+
+// AddWebhookHandler subscribes the form's url to its events (every event if
+// none are checked). The secret is generated when left blank.
+func AddWebhookHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		webhook, err := services.AddWebhook(db, strings.TrimSpace(c.PostForm("url")), strings.TrimSpace(c.PostForm("secret")), c.PostFormArray("events"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook added", "id": webhook.ID, "secret": webhook.Secret})
+	}
+}
+
+func DeleteWebhookHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+			return
+		}
+		if err := services.DeleteWebhook(db, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+	}
+}
+
+// ToggleWebhookHandler pauses or resumes a webhook from the form field enabled
+func ToggleWebhookHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+			return
+		}
+		enabled := c.PostForm("enabled") == "true"
+		if err := services.SetWebhookEnabled(db, id, enabled); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+			return
+		}
+		if enabled {
+			c.JSON(http.StatusOK, gin.H{"message": "Webhook resumed"})
+		} else {
+			c.JSON(http.StatusOK, gin.H{"message": "Webhook paused"})
+		}
+	}
+}
+
+func RedeliverWebhookHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+			return
+		}
+		if err := services.RedeliverWebhook(db, id); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Delivery queued"})
+	}
+}
@@ -1,28 +1,143 @@
 package handlers
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/Jake-Schuler/MoSim-Event-Manager/services"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// WebSocketHandler serves the public feed. Connections are read-only unless
+// they present an API token as ?token= or an Authorization: Bearer header.
 func WebSocketHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Log the incoming request
-		log.Printf("WebSocket upgrade request from %s", c.ClientIP())
-		log.Printf("Headers: %v", c.Request.Header)
+		role := services.RoleReadOnly
+		if token := requestToken(c); token != "" {
+			var ok bool
+			role, ok = services.RoleForToken(token)
+			if !ok {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API token"})
+				return
+			}
+		}
+		serveWebSocket(c, db, role)
+	}
+}
+
+// AdminWebSocketHandler serves the feed to authenticated admin sessions
+func AdminWebSocketHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serveWebSocket(c, db, services.RoleAdmin)
+	}
+}
+
+func serveWebSocket(c *gin.Context, db *gorm.DB, role services.Role) {
+	// Log the incoming request
+	log.Printf("WebSocket upgrade request from %s", c.ClientIP())
+
+	// Topics can be chosen at connect time, e.g. /ws?topics=match,player:4
+	topics, err := services.ParseTopics(c.Query("topics"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		conn, err := services.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	conn, err := services.Upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+
+	// Handle the WebSocket connection using the service
+	services.HandleWebSocketConnection(conn, db, topics, role)
+}
+
+func requestToken(c *gin.Context) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// RequestLogger is gin's access log with API tokens left out, since the
+// overlay passes its token as ?token=
+func RequestLogger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			param.StatusCode,
+			param.Latency,
+			param.ClientIP,
+			param.Method,
+			redactToken(param.Path),
+			param.ErrorMessage,
+		)
+	})
+}
+
+// redactToken hides the token in a logged path and query
+func redactToken(path string) string {
+	route, rawQuery, found := strings.Cut(path, "?")
+	if !found {
+		return path
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return route + "?[unparsable query]"
+	}
+	if query.Has("token") {
+		query.Set("token", "REDACTED")
+	}
+	return route + "?" + query.Encode()
+}
+
+// WebSocketProtocolHandler publishes the WebSocket message schemas for overlay authors
+func WebSocketProtocolHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, services.Protocol.Describe())
+	}
+}
+
+// EventsHandler serves the same feed as /ws over Server-Sent Events
+func EventsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !services.CheckOrigin(c.Request) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Origin not allowed"})
+			return
+		}
+		if origin := c.GetHeader("Origin"); origin != "" {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+
+		topics, err := services.ParseTopics(c.Query("topics"))
 		if err != nil {
-			log.Printf("WebSocket upgrade error: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not upgrade connection"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Handle the WebSocket connection using the service
-		services.HandleWebSocketConnection(conn, db)
+		// EventSource sends Last-Event-ID on reconnect; allow it as a query
+		// parameter too for clients that can't set headers
+		lastEventID := c.GetHeader("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = c.Query("last_event_id")
+		}
+		var lastSeq uint64
+		if lastEventID != "" {
+			if lastSeq, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+				return
+			}
+		}
+
+		services.ServeEvents(c.Writer, c.Request, topics, lastSeq)
 	}
 }
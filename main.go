@@ -1,9 +1,18 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
+	"flag"
 	"html/template"
+	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -20,33 +29,114 @@ var static embed.FS
 var templates embed.FS
 
 func main() {
-	// Load environment variables
+	migrateOnly := flag.Bool("migrate-only", false, "apply pending database migrations and exit")
+	rollback := flag.Int("rollback", 0, "revert this many of the latest database migrations and exit")
+	force := flag.Bool("force", false, "with -rollback, also revert migrations that delete the event")
+	flag.Parse()
+
+	// Load environment variables. Without the file, settings come from the
+	// environment alone.
 	if err := godotenv.Load("data/.env"); err != nil {
-		panic("Error loading .env file")
+		log.Println("No data/.env file, using the environment")
+	}
+
+	if *rollback > 0 {
+		db, err := config.ConnectDB()
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer config.CloseDB(db)
+		if err := config.MigrateDown(db, *rollback, *force); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// Initialize database
+	// Initialize database. Everything shares this one connection pool.
 	db := config.InitDB()
+	if *migrateOnly {
+		log.Println("Database is up to date")
+		config.CloseDB(db)
+		return
+	}
 
 	// Initialize WebSocket state from database
 	services.InitializeWebSocketState(db)
 
+	// Restore the schedule estimate settings
+	if err := services.LoadScheduleTiming(db); err != nil {
+		log.Printf("Error loading schedule timing: %v", err)
+	}
+
 	// Initialize MMID counter based on existing users
 	services.GetMMID(db)
 
-	// Initialize Discord Bot
-	dg := config.InitDiscordBot()
+	// Send webhook deliveries, including retries left over from the last run
+	services.Webhooks.Start(db)
+
+	// Initialize Discord Bot, carrying on without announcements if it can't connect
+	var notifier services.Notifier = services.NoopNotifier{}
+	var guild services.GuildManager = services.NoopGuild{}
+	dg, err := config.InitDiscordBot()
+	if err != nil {
+		log.Printf("Running without Discord: %v", err)
+	} else {
+		notifier = services.NewDiscordNotifier(dg)
+		if guildID := os.Getenv("DISCORD_GUILD_ID"); guildID != "" {
+			guild = services.NewDiscordGuild(dg, guildID)
+		} else {
+			log.Println("DISCORD_GUILD_ID is not set, so roles and channels won't be managed")
+		}
+		handlers.SetupDiscordCommands(dg, db, notifier, guild)
+	}
 
 	// Initialize Gin router
-	r := gin.Default()
+	r := gin.New()
+	r.Use(handlers.RequestLogger(), gin.Recovery())
 	r.SetHTMLTemplate(template.Must(template.New("").ParseFS(templates, "templates/*")))
 	r.StaticFS("/static", http.FS(static))
 
 	// Setup routes
-	handlers.SetupRoutes(r, db, dg)
+	handlers.SetupRoutes(r, db, notifier, guild)
+
+	// Start server, and stop it cleanly on Ctrl+C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serverCtx, cancelServer := context.WithCancel(context.Background())
+	defer cancelServer()
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: r,
+		// Requests see the server stopping through their context
+		BaseContext: func(net.Listener) context.Context { return serverCtx },
+	}
+	// Shutdown doesn't wait for hijacked WebSockets or open event streams,
+	// so end them ourselves
+	server.RegisterOnShutdown(func() {
+		cancelServer()
+		services.Manager.CloseAll()
+	})
+	go func() {
+		log.Printf("Listening on %s", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+	<-ctx.Done()
 
-	// Start server
-	if err := r.Run(":8080"); err != nil {
-		panic("failed to start server")
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error stopping server: %v", err)
+	}
+	if dg != nil {
+		dg.Close()
+	}
+	// Stop background work before the database closes under it
+	services.Field.Stop()
+	services.Webhooks.Stop()
+	if err := config.CloseDB(db); err != nil {
+		log.Printf("Error closing database: %v", err)
 	}
 }
@@ -0,0 +1,31 @@
+package models
+
+// DiscordSettings holds where and how the bot announces things for the
+// event. Empty channels fall back to DISCORD_CHANNEL_ID and empty templates
+// to the built-in wording.
+type DiscordSettings struct {
+	ID                int    `gorm:"primaryKey"`
+	MatchChannelID    string // Match calls and on-deck pings
+	ResultsChannelID  string
+	RankingsChannelID string
+	AllianceChannelID string // Alliance picks and playoff advancement
+	RankingsSize      int    // How many players the rankings list
+	RankingsEvery     int    // Without time blocks, post rankings after this many matches
+	MatchCallTemplate string
+	OnDeckTemplate    string
+	ResultsTemplate   string // Title of the results embed
+	AllianceTemplate  string
+	AdvanceTemplate   string
+	ManageRoles       bool // Grant roles automatically on registration and alliance selection
+	RolesDryRun       bool // Log the role changes instead of making them
+}
+
+// DiscordResource is a role or channel the bot created for the event, kept so
+// it can all be removed when the event is archived
+type DiscordResource struct {
+	ID        int    `gorm:"primaryKey"`
+	Purpose   string `gorm:"uniqueIndex"` // e.g. "participant", "alliance-3-text"
+	Kind      string // "role" or "channel"
+	Name      string
+	DiscordID string
+}
@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type QualsMatch struct {
 	ID               int `gorm:"primaryKey"`
 	RedPlayerID      int `gorm:"not null"`
@@ -16,12 +18,19 @@ type QualsMatch struct {
 	BlueWinRP        int
 	RedBonusRP       int
 	BlueBonusRP      int
+	ScheduledStart   *time.Time // Nil until the schedule has time blocks
+	CommittedAt      *time.Time // When scores were first saved
+	// A surrogate plays an extra match to fill out the schedule. It counts
+	// for their opponent but not towards their own ranking.
+	RedSurrogate  bool
+	BlueSurrogate bool
 }
 
 type PlayoffMatch struct {
-	ID           int `gorm:"primaryKey"`
-	RedAlliance  int `gorm:"not null"`
-	BlueAlliance int `gorm:"not null"`
+	ID           int    `gorm:"primaryKey"`
+	Name         string `gorm:"uniqueIndex"` // e.g. "Upper Final"
+	RedAlliance  int    `gorm:"not null"`
+	BlueAlliance int    `gorm:"not null"`
 	RedScore     int
 	BlueScore    int
 }
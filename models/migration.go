@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// SchemaMigration records a schema migration that was applied to the
+// database
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// RankingSnapshot marks the rankings right after a qualification match was
+// committed. Only the players whose standing changed get a RankingChange,
+// so everyone else keeps their rank from an earlier snapshot.
+type RankingSnapshot struct {
+	ID        int  `gorm:"primaryKey"`
+	MatchID   int  // The match whose commit caused the snapshot
+	Corrected bool // The match had already been committed before
+	TakenAt   time.Time
+}
+
+// RankingChange is a player's new rank and total RP as of a snapshot
+type RankingChange struct {
+	ID         int `gorm:"primaryKey"`
+	SnapshotID int `gorm:"index"`
+	MMID       int `gorm:"index"`
+	Rank       int
+	TotalRP    int
+}
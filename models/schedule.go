@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// ScheduleBlock is a run of qualification matches played back to back. The
+// gap between the end of one block and the start of the next is a planned
+// break, such as lunch.
+type ScheduleBlock struct {
+	ID           int       `gorm:"primaryKey" json:"id"`
+	Label        string    `json:"label"`
+	Start        time.Time `gorm:"not null" json:"start"`
+	CycleSeconds int       `gorm:"not null" json:"cycle_seconds"`
+	MatchCount   int       `json:"match_count"` // 0 lets the block run until every match is placed
+}
+
+// ScheduleBreak is an unplanned pause after a match that pushes every later
+// match back
+type ScheduleBreak struct {
+	ID              int       `gorm:"primaryKey" json:"id"`
+	AfterMatchID    int       `gorm:"not null" json:"after_match_id"`
+	DurationSeconds int       `gorm:"not null" json:"duration_seconds"`
+	Label           string    `json:"label"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ScheduleTiming is the event's one row of estimate settings: the cycle time
+// of matches outside a block and when the first match is planned
+type ScheduleTiming struct {
+	ID           int        `gorm:"primaryKey"`
+	CycleSeconds int        `gorm:"not null"`
+	EventStart   *time.Time // Nil plans the schedule from the first committed match
+}
@@ -0,0 +1,86 @@
+package models
+
+import "time"
+
+// Webhook is an outside service that gets a signed POST for each event it
+// subscribes to
+type Webhook struct {
+	ID        int       `gorm:"primaryKey" json:"id"`
+	URL       string    `gorm:"not null" json:"url"`
+	Secret    string    `json:"-"`
+	Events    string    `json:"events"` // Comma separated; empty subscribes to every event
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent to one webhook, kept as a log of what
+// was delivered and what failed
+type WebhookDelivery struct {
+	ID          int        `gorm:"primaryKey" json:"id"`
+	WebhookID   int        `gorm:"index" json:"webhook_id"`
+	Event       string     `json:"event"`
+	Payload     string     `json:"payload"`
+	Attempts    int        `json:"attempts"`
+	StatusCode  int        `json:"status_code"` // Of the last attempt, 0 if it never got a response
+	Error       string     `json:"error"`
+	Delivered   bool       `json:"delivered"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+	// Retries made since it was fired or redelivered, and when the next
+	// attempt is due; nil once it's delivered or given up on
+	Retries       int        `json:"retries"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at"`
+}
+
+// WebhookEnvelope is the body of every webhook POST
+type WebhookEnvelope struct {
+	Event     string      `json:"event"`
+	Delivery  int         `json:"delivery"`
+	Timestamp time.Time   `json:"timestamp"`
+	EventName string      `json:"event_name"`
+	Data      interface{} `json:"data"`
+}
+
+type WebhookUserPayload struct {
+	MMID     int    `json:"mmid"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+}
+
+type WebhookScheduledMatch struct {
+	MatchID        int        `json:"match_id"`
+	RedMMID        int        `json:"red_mmid"`
+	BlueMMID       int        `json:"blue_mmid"`
+	RedSurrogate   bool       `json:"red_surrogate"`
+	BlueSurrogate  bool       `json:"blue_surrogate"`
+	ScheduledStart *time.Time `json:"scheduled_start"`
+}
+
+type WebhookSchedulePayload struct {
+	Matches []WebhookScheduledMatch `json:"matches"`
+}
+
+type WebhookScorePayload struct {
+	MatchID          int    `json:"match_id"`
+	RedName          string `json:"red_name"`
+	BlueName         string `json:"blue_name"`
+	RedScore         int    `json:"red_score"`
+	BlueScore        int    `json:"blue_score"`
+	RedAutoScore     int    `json:"red_auto_score"`
+	BlueAutoScore    int    `json:"blue_auto_score"`
+	RedTeleopScore   int    `json:"red_teleop_score"`
+	BlueTeleopScore  int    `json:"blue_teleop_score"`
+	RedEndgameScore  int    `json:"red_endgame_score"`
+	BlueEndgameScore int    `json:"blue_endgame_score"`
+	RedRP            int    `json:"red_rp"`
+	BlueRP           int    `json:"blue_rp"`
+	Corrected        bool   `json:"corrected"` // Scores of a match that was already committed were changed
+}
+
+type WebhookAdvancePayload struct {
+	Match       string `json:"match"`
+	Winner      int    `json:"winner"`
+	Loser       int    `json:"loser"`
+	WinnerScore int    `json:"winner_score"`
+	LoserScore  int    `json:"loser_score"`
+}
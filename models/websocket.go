@@ -1,21 +1,27 @@
 package models
 
-type WebSocketMessage struct {
-	Type    string      `json:"type"`
-	Payload interface{} `json:"payload"`
-}
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
 
-type WebSocketResponse struct {
+type WebSocketMessage struct {
 	Type    string      `json:"type"`
+	Version int         `json:"version"`
+	Seq     uint64      `json:"seq,omitempty"` // Set on broadcasts and snapshots
 	Payload interface{} `json:"payload"`
 }
 
 type WebSocketMatchPayload struct {
-	MatchLevel   string   `json:"match_level"`
-	MatchID      int      `json:"match_id"`
-	EventName    string   `json:"event_name"`
-	RedAlliance  []string `json:"red_alliance"`
-	BlueAlliance []string `json:"blue_alliance"`
+	MatchLevel    string   `json:"match_level"`
+	MatchID       int      `json:"match_id"`
+	EventName     string   `json:"event_name"`
+	RedAlliance   []string `json:"red_alliance"`
+	BlueAlliance  []string `json:"blue_alliance"`
+	RedSurrogate  bool     `json:"red_surrogate"`
+	BlueSurrogate bool     `json:"blue_surrogate"`
 }
 
 type WebSocketLeaderboardPayload struct {
@@ -27,8 +33,10 @@ type WebSocketLeaderboardTogglePayload struct {
 }
 
 type WebSocketMatchSavedPayload struct {
-	RedAlliance  []string `json:"red_alliance"`
-	BlueAlliance []string `json:"blue_alliance"`
+	RedAlliance   []string `json:"red_alliance"`
+	BlueAlliance  []string `json:"blue_alliance"`
+	RedSurrogate  bool     `json:"red_surrogate"`
+	BlueSurrogate bool     `json:"blue_surrogate"`
 }
 
 type WebSocketAllianceSelectionPayload struct {
@@ -40,3 +48,113 @@ type WebSocketAllianceSelectionPayload struct {
 type WebSocketToggleAllianceSlectionPayload struct {
 	Show bool `json:"show"`
 }
+
+// WebSocketInboundMessage is the envelope used when decoding client messages.
+// The payload is kept raw until the message type is known.
+type WebSocketInboundMessage struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type WebSocketEmptyPayload struct{}
+
+type WebSocketTeamSelectedPayload struct {
+	Username string `json:"username"`
+}
+
+func (p WebSocketTeamSelectedPayload) Validate() error {
+	if strings.TrimSpace(p.Username) == "" {
+		return errors.New("username is required")
+	}
+	return nil
+}
+
+type WebSocketTeamSelectionPayload struct {
+	Username string `json:"username"`
+}
+
+type WebSocketAvailableTeamsPayload struct {
+	Teams []User `json:"teams"`
+}
+
+type WebSocketErrorPayload struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	RequestType string `json:"request_type,omitempty"`
+}
+
+type WebSocketSubscribePayload struct {
+	Topics []string `json:"topics"`
+}
+
+func (p WebSocketSubscribePayload) Validate() error {
+	if len(p.Topics) == 0 {
+		return errors.New("at least one topic is required")
+	}
+	return nil
+}
+
+type WebSocketSubscriptionsPayload struct {
+	Topics []string `json:"topics"`
+}
+
+type WebSocketStateSnapshotPayload struct {
+	Seq                      uint64                              `json:"seq"`
+	EventName                string                              `json:"event_name"`
+	ActiveMatch              *WebSocketMatchPayload              `json:"active_match"`
+	Leaderboard              []User                              `json:"leaderboard"`
+	LeaderboardVisible       bool                                `json:"leaderboard_visible"`
+	AllianceSelectionVisible bool                                `json:"alliance_selection_visible"`
+	AllianceSelections       []WebSocketAllianceSelectionPayload `json:"alliance_selections"`
+	MatchClock               WebSocketMatchClockPayload          `json:"match_clock"`
+	Queue                    WebSocketQueuePayload               `json:"queue"`
+}
+
+type WebSocketResumePayload struct {
+	LastSeq uint64 `json:"last_seq"`
+}
+
+func (p WebSocketResumePayload) Validate() error {
+	if p.LastSeq == 0 {
+		return errors.New("last_seq is required")
+	}
+	return nil
+}
+
+type WebSocketMatchClockPayload struct {
+	State             string `json:"state"`
+	MatchLevel        string `json:"match_level"`
+	MatchID           int    `json:"match_id"`
+	Paused            bool   `json:"paused"`
+	PeriodDurationMs  int64  `json:"period_duration_ms"`
+	PeriodRemainingMs int64  `json:"period_remaining_ms"`
+	MatchRemainingMs  int64  `json:"match_remaining_ms"`
+	AutoSeconds       int    `json:"auto_seconds"`
+	TransitionSeconds int    `json:"transition_seconds"`
+	TeleopSeconds     int    `json:"teleop_seconds"`
+	EndgameSeconds    int    `json:"endgame_seconds"`
+}
+
+type WebSocketQueueEntry struct {
+	MatchLevel    string   `json:"match_level"`
+	MatchID       int      `json:"match_id"`
+	RedAlliance   []string `json:"red_alliance"`
+	BlueAlliance  []string `json:"blue_alliance"`
+	RedSurrogate  bool     `json:"red_surrogate"`
+	BlueSurrogate bool     `json:"blue_surrogate"`
+}
+
+type WebSocketQueuePayload struct {
+	Now     *WebSocketQueueEntry  `json:"now"`
+	OnDeck  *WebSocketQueueEntry  `json:"on_deck"`
+	InQueue []WebSocketQueueEntry `json:"in_queue"`
+}
+
+// WebSocketScheduleUpdatePayload says how far ahead or behind the event is
+// running. Per-match estimates are served from /api/schedule.
+type WebSocketScheduleUpdatePayload struct {
+	FirstMatchStart time.Time `json:"first_match_start"`
+	CycleSeconds    int       `json:"cycle_seconds"`
+	DriftSeconds    int64     `json:"drift_seconds"`
+}
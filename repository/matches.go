@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// MatchWithPlayers is a qualification match with both of its players. A
+// player that can't be found is left empty, with an MMID of 0.
+type MatchWithPlayers struct {
+	models.QualsMatch
+	Red  models.User
+	Blue models.User
+}
+
+// Matches loads every qualification match in order along with its players,
+// in two queries however many matches there are
+func Matches(db *gorm.DB) ([]MatchWithPlayers, error) {
+	var matches []models.QualsMatch
+	if err := db.Order("id").Find(&matches).Error; err != nil {
+		return nil, err
+	}
+	players, err := PlayersByMMID(db)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make([]MatchWithPlayers, len(matches))
+	for i, match := range matches {
+		loaded[i] = MatchWithPlayers{
+			QualsMatch: match,
+			Red:        players[match.RedPlayerID],
+			Blue:       players[match.BluePlayerID],
+		}
+	}
+	return loaded, nil
+}
+
+// PlayersByMMID loads every player keyed by MMID
+func PlayersByMMID(db *gorm.DB) (map[int]models.User, error) {
+	var users []models.User
+	if err := db.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	players := make(map[int]models.User, len(users))
+	for _, user := range users {
+		players[user.MMID] = user
+	}
+	return players, nil
+}
@@ -0,0 +1,74 @@
+package repository_test
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/repository"
+)
+
+// legacyMatches is how the schedule was loaded before Matches: two queries
+// for every match
+func legacyMatches(db *gorm.DB) ([]repository.MatchWithPlayers, error) {
+	var matches []models.QualsMatch
+	if err := db.Order("id").Find(&matches).Error; err != nil {
+		return nil, err
+	}
+	loaded := make([]repository.MatchWithPlayers, len(matches))
+	for i, match := range matches {
+		loaded[i].QualsMatch = match
+		db.Where("mm_id = ?", match.RedPlayerID).First(&loaded[i].Red)
+		db.Where("mm_id = ?", match.BluePlayerID).First(&loaded[i].Blue)
+	}
+	return loaded, nil
+}
+
+func TestMatchesMatchLegacy(t *testing.T) {
+	db := openTestDB(t)
+	seedEvent(t, db, 20, 100, 2)
+	// A player that has left leaves an empty side
+	if err := db.Delete(&models.User{}, 7).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := legacyMatches(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := repository.Matches(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d matches, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || got[i].Red != want[i].Red || got[i].Blue != want[i].Blue {
+			t.Errorf("match %d: got %d vs %d, want %d vs %d", want[i].ID, got[i].Red.MMID, got[i].Blue.MMID, want[i].Red.MMID, want[i].Blue.MMID)
+		}
+	}
+}
+
+func BenchmarkMatches(b *testing.B) {
+	db := openTestDB(b)
+	seedEvent(b, db, 500, 3000, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repository.Matches(db); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLegacyMatches(b *testing.B) {
+	db := openTestDB(b)
+	seedEvent(b, db, 500, 3000, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := legacyMatches(db); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// sides lists each player's appearances, one row per side of every match.
+// Surrogate appearances are left out since they don't count towards the
+// player's ranking. Win RP only goes to the side that outscored the other.
+const sides = `
+SELECT red_player_id AS mm_id,
+	CASE WHEN red_score > blue_score THEN red_win_rp ELSE 0 END AS win_rp,
+	red_bonus_rp AS bonus_rp,
+	red_auto_score AS auto_points,
+	red_teleop_score AS teleop_points,
+	red_endgame_score AS endgame_points
+FROM quals_matches WHERE red_surrogate IS NOT TRUE
+UNION ALL
+SELECT blue_player_id,
+	CASE WHEN blue_score > red_score THEN blue_win_rp ELSE 0 END,
+	blue_bonus_rp,
+	blue_auto_score,
+	blue_teleop_score,
+	blue_endgame_score
+FROM quals_matches WHERE blue_surrogate IS NOT TRUE`
+
+// Rankings totals every player's RP and points in a single grouped query and
+// ranks them by total RP, ties going to the lower MMID
+func Rankings(db *gorm.DB) ([]models.User, error) {
+	var users []models.User
+	err := db.Raw(`
+SELECT users.id, users.username, users.prefered_username, users.mm_id,
+	COALESCE(SUM(sides.win_rp), 0) + COALESCE(SUM(sides.bonus_rp), 0) AS total_rp,
+	COALESCE(SUM(sides.win_rp), 0) AS win_rp,
+	COALESCE(SUM(sides.bonus_rp), 0) AS bonus_rp,
+	COALESCE(SUM(sides.auto_points), 0) + COALESCE(SUM(sides.teleop_points), 0) + COALESCE(SUM(sides.endgame_points), 0) AS total_points,
+	COALESCE(SUM(sides.auto_points), 0) AS auto_points,
+	COALESCE(SUM(sides.teleop_points), 0) AS teleop_points,
+	COALESCE(SUM(sides.endgame_points), 0) AS endgame_points
+FROM users
+LEFT JOIN (` + sides + `) sides ON sides.mm_id = users.mm_id
+GROUP BY users.id, users.username, users.prefered_username, users.mm_id
+ORDER BY total_rp DESC, users.mm_id`).Scan(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		users[i].Rank = i + 1
+	}
+	return users, nil
+}
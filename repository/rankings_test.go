@@ -0,0 +1,167 @@
+package repository_test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/repository"
+)
+
+// legacyRankings is how the leaderboard was worked out before Rankings: a
+// query for each player, totalled in Go. Rankings has to agree with it.
+func legacyRankings(db *gorm.DB) ([]models.User, error) {
+	var users []models.User
+	if err := db.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for i := range users {
+		var matches []models.QualsMatch
+		if err := db.Where("red_player_id = ? OR blue_player_id = ?", users[i].MMID, users[i].MMID).Find(&matches).Error; err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			mmid := users[i].MMID
+			if match.RedPlayerID == mmid && match.RedSurrogate || match.BluePlayerID == mmid && match.BlueSurrogate {
+				continue
+			}
+			if match.RedPlayerID == mmid {
+				if match.RedScore > match.BlueScore {
+					users[i].WinRP += match.RedWinRP
+				}
+				users[i].BonusRP += match.RedBonusRP
+				users[i].AutoPoints += match.RedAutoScore
+				users[i].TeleopPoints += match.RedTeleopScore
+				users[i].EndgamePoints += match.RedEndgameScore
+			} else {
+				if match.BlueScore > match.RedScore {
+					users[i].WinRP += match.BlueWinRP
+				}
+				users[i].BonusRP += match.BlueBonusRP
+				users[i].AutoPoints += match.BlueAutoScore
+				users[i].TeleopPoints += match.BlueTeleopScore
+				users[i].EndgamePoints += match.BlueEndgameScore
+			}
+		}
+		users[i].TotalRP = users[i].WinRP + users[i].BonusRP
+		users[i].TotalPoints = users[i].AutoPoints + users[i].TeleopPoints + users[i].EndgamePoints
+	}
+	sort.SliceStable(users, func(i, j int) bool { return users[i].TotalRP > users[j].TotalRP })
+	for i := range users {
+		users[i].Rank = i + 1
+	}
+	return users, nil
+}
+
+func TestRankingsMatchLegacyTotals(t *testing.T) {
+	db := openTestDB(t)
+	seedEvent(t, db, 60, 400, 1)
+
+	want, err := legacyRankings(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := repository.Rankings(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d players, want %d", len(got), len(want))
+	}
+
+	byMMID := map[int]models.User{}
+	for _, user := range want {
+		user.Rank = 0
+		byMMID[user.MMID] = user
+	}
+	for i, user := range got {
+		if i > 0 {
+			previous := got[i-1]
+			if previous.TotalRP < user.TotalRP || previous.TotalRP == user.TotalRP && previous.MMID > user.MMID {
+				t.Errorf("rank %d (%d RP, MMID %d) is ahead of rank %d (%d RP, MMID %d)",
+					previous.Rank, previous.TotalRP, previous.MMID, user.Rank, user.TotalRP, user.MMID)
+			}
+		}
+		if user.Rank != i+1 {
+			t.Errorf("player %d is ranked %d at position %d", user.MMID, user.Rank, i+1)
+		}
+		user.Rank = 0
+		if user != byMMID[user.MMID] {
+			t.Errorf("player %d: got %+v, want %+v", user.MMID, user, byMMID[user.MMID])
+		}
+	}
+}
+
+func TestRankingsCountsOnlyRankedResults(t *testing.T) {
+	db := openTestDB(t)
+	for mmid := 1; mmid <= 3; mmid++ {
+		name := string(rune('a' + mmid - 1))
+		if err := db.Create(&models.User{ID: mmid, Username: name, PreferedUsername: name, MMID: mmid}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	played := time.Now()
+	matches := []models.QualsMatch{
+		// 1 beats 2
+		{ID: 1, RedPlayerID: 1, BluePlayerID: 2, RedScore: 5, BlueScore: 2, RedAutoScore: 5, BlueAutoScore: 2, RedWinRP: 3, RedBonusRP: 1, CommittedAt: &played},
+		// A tie gives neither side win RP, only bonus RP
+		{ID: 2, RedPlayerID: 2, BluePlayerID: 3, RedScore: 4, BlueScore: 4, RedTeleopScore: 4, BlueTeleopScore: 4, RedWinRP: 1, BlueWinRP: 1, BlueBonusRP: 1, CommittedAt: &played},
+		// 3 plays as a surrogate: the win counts for nothing, the loss still counts for 1's points
+		{ID: 3, RedPlayerID: 3, BluePlayerID: 1, RedScore: 9, BlueScore: 1, RedEndgameScore: 9, BlueEndgameScore: 1, RedWinRP: 3, RedBonusRP: 1, RedSurrogate: true, CommittedAt: &played},
+		// Not played yet
+		{ID: 4, RedPlayerID: 1, BluePlayerID: 3},
+	}
+	if err := db.Create(&matches).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := repository.Rankings(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		mmid, totalRP, winRP, bonusRP, totalPoints int
+	}{
+		{1, 4, 3, 1, 6},
+		{3, 1, 0, 1, 4},
+		{2, 0, 0, 0, 6},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d players, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		user := got[i]
+		if user.MMID != w.mmid || user.Rank != i+1 || user.TotalRP != w.totalRP || user.WinRP != w.winRP || user.BonusRP != w.bonusRP || user.TotalPoints != w.totalPoints {
+			t.Errorf("rank %d: got MMID %d with %d RP (%d win, %d bonus) and %d points, want MMID %d with %d RP (%d win, %d bonus) and %d points",
+				i+1, user.MMID, user.TotalRP, user.WinRP, user.BonusRP, user.TotalPoints, w.mmid, w.totalRP, w.winRP, w.bonusRP, w.totalPoints)
+		}
+	}
+}
+
+// The benchmarks use an event the size of a large league: 500 players and
+// 3000 matches
+
+func BenchmarkRankings(b *testing.B) {
+	db := openTestDB(b)
+	seedEvent(b, db, 500, 3000, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repository.Rankings(db); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLegacyRankings(b *testing.B) {
+	db := openTestDB(b)
+	seedEvent(b, db, 500, 3000, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := legacyRankings(db); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// Built-in wording for announcements without a custom template
+const (
+	DefaultMatchCallTemplate = "{{.Level}} {{.MatchID}} will be {{.Red}} vs. {{.Blue}}"
+	DefaultOnDeckTemplate    = "{{.Level}} {{.MatchID}} is on deck: {{.Red}} vs. {{.Blue}}. Get ready!"
+	DefaultResultsTemplate   = "Quals {{.MatchID}}: {{.RedName}} {{.RedScore}} – {{.BlueScore}} {{.BlueName}}"
+	DefaultAllianceTemplate  = "Alliance {{.Alliance}} captain {{.Captain}} picks {{.Selection}}!"
+	DefaultAdvanceTemplate   = "Alliance {{.Winner}} beats Alliance {{.Loser}} {{.WinnerScore}}–{{.LoserScore}} in {{.Match}} and advances!"
+
+	DefaultRankingsSize  = 8
+	DefaultRankingsEvery = 10
+)
+
+// Embed colors
+const (
+	redColor  = 0xE53E3E
+	blueColor = 0x3182CE
+	tieColor  = 0xA0AEC0
+	rankColor = 0x4FD1C7
+)
+
+// MatchAnnouncement is the data for match call and on-deck templates. Red
+// and Blue mention the players; RedName and BlueName don't.
+type MatchAnnouncement struct {
+	Level    string
+	MatchID  int
+	Red      string
+	Blue     string
+	RedName  string
+	BlueName string
+}
+
+// ResultsAnnouncement is the data for the results embed title
+type ResultsAnnouncement struct {
+	MatchID   int
+	RedName   string
+	BlueName  string
+	RedScore  int
+	BlueScore int
+}
+
+// AllianceAnnouncement is the data for alliance pick templates
+type AllianceAnnouncement struct {
+	Alliance  int
+	Captain   string
+	Selection string
+}
+
+// AdvanceAnnouncement is the data for playoff advancement templates
+type AdvanceAnnouncement struct {
+	Match       string
+	Winner      int
+	Loser       int
+	WinnerScore int
+	LoserScore  int
+}
+
+// GetDiscordSettings loads the event's Discord settings with defaults filled in
+func GetDiscordSettings(db *gorm.DB) models.DiscordSettings {
+	var settings models.DiscordSettings
+	if err := db.Limit(1).Find(&settings).Error; err != nil {
+		log.Printf("Error loading Discord settings: %v", err)
+	}
+	settings.ID = 1
+
+	if settings.RankingsSize <= 0 {
+		settings.RankingsSize = DefaultRankingsSize
+	}
+	if settings.RankingsEvery <= 0 {
+		settings.RankingsEvery = DefaultRankingsEvery
+	}
+	defaults := []struct {
+		target   *string
+		fallback string
+	}{
+		{&settings.MatchCallTemplate, DefaultMatchCallTemplate},
+		{&settings.OnDeckTemplate, DefaultOnDeckTemplate},
+		{&settings.ResultsTemplate, DefaultResultsTemplate},
+		{&settings.AllianceTemplate, DefaultAllianceTemplate},
+		{&settings.AdvanceTemplate, DefaultAdvanceTemplate},
+	}
+	for _, d := range defaults {
+		if strings.TrimSpace(*d.target) == "" {
+			*d.target = d.fallback
+		}
+	}
+	return settings
+}
+
+// SaveDiscordSettings stores the event's Discord settings after checking
+// every template renders
+func SaveDiscordSettings(db *gorm.DB, settings models.DiscordSettings) error {
+	templates := []struct {
+		name string
+		text string
+		data interface{}
+	}{
+		{"match call", settings.MatchCallTemplate, MatchAnnouncement{}},
+		{"on deck", settings.OnDeckTemplate, MatchAnnouncement{}},
+		{"results", settings.ResultsTemplate, ResultsAnnouncement{}},
+		{"alliance pick", settings.AllianceTemplate, AllianceAnnouncement{}},
+		{"playoff advancement", settings.AdvanceTemplate, AdvanceAnnouncement{}},
+	}
+	for _, t := range templates {
+		if _, err := renderTemplate(t.text, t.data); err != nil {
+			return fmt.Errorf("invalid %s template: %v", t.name, err)
+		}
+	}
+	if settings.RankingsSize < 0 || settings.RankingsEvery < 0 {
+		return errors.New("rankings size and frequency can't be negative")
+	}
+
+	settings.ID = 1
+	return db.Save(&settings).Error
+}
+
+func renderTemplate(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("announcement").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// render fills in a template, falling back to the built-in wording if a
+// stored template no longer works
+func render(text string, fallback string, data interface{}) string {
+	out, err := renderTemplate(text, data)
+	if err != nil {
+		log.Printf("Error rendering announcement template: %v", err)
+		out, _ = renderTemplate(fallback, data)
+	}
+	return out
+}
+
+// announcementChannel falls back to DISCORD_CHANNEL_ID for channels the
+// event hasn't set
+func announcementChannel(channelID string) string {
+	if channelID != "" {
+		return channelID
+	}
+	return os.Getenv("DISCORD_CHANNEL_ID")
+}
+
+func mention(user models.User) string {
+	if user.ID == 0 {
+		return "TBD"
+	}
+	return "<@" + strconv.Itoa(user.ID) + ">"
+}
+
+func matchAnnouncement(db *gorm.DB, level string, match models.QualsMatch) MatchAnnouncement {
+	var redPlayer, bluePlayer models.User
+	db.Where("mm_id = ?", match.RedPlayerID).First(&redPlayer)
+	db.Where("mm_id = ?", match.BluePlayerID).First(&bluePlayer)
+	return MatchAnnouncement{
+		Level:    level,
+		MatchID:  match.ID,
+		Red:      mention(redPlayer),
+		Blue:     mention(bluePlayer),
+		RedName:  DisplayName(redPlayer),
+		BlueName: DisplayName(bluePlayer),
+	}
+}
+
+// AnnounceMatchCall tells the players of a match that it's on the field
+func AnnounceMatchCall(notifier Notifier, db *gorm.DB, level string, match models.QualsMatch) {
+	settings := GetDiscordSettings(db)
+	message := render(settings.MatchCallTemplate, DefaultMatchCallTemplate, matchAnnouncement(db, level, match))
+	if err := notifier.SendMessage(announcementChannel(settings.MatchChannelID), message); err != nil {
+		log.Printf("Error announcing match call: %v", err)
+	}
+}
+
+// AnnounceOnDeck pings the players of the match that just went on deck
+func AnnounceOnDeck(notifier Notifier, db *gorm.DB, match models.QualsMatch) {
+	settings := GetDiscordSettings(db)
+	message := render(settings.OnDeckTemplate, DefaultOnDeckTemplate, matchAnnouncement(db, "Quals", match))
+	if err := notifier.SendMessage(announcementChannel(settings.MatchChannelID), message); err != nil {
+		log.Printf("Error announcing on deck match: %v", err)
+	}
+}
+
+// MatchRP is the ranking points each side earned from a match, counted the
+// same way as the leaderboard. A surrogate side earns none.
+func MatchRP(match models.QualsMatch) (int, int) {
+	redRP, blueRP := match.RedBonusRP, match.BlueBonusRP
+	if match.RedScore > match.BlueScore {
+		redRP += match.RedWinRP
+	} else if match.BlueScore > match.RedScore {
+		blueRP += match.BlueWinRP
+	}
+	if match.RedSurrogate {
+		redRP = 0
+	}
+	if match.BlueSurrogate {
+		blueRP = 0
+	}
+	return redRP, blueRP
+}
+
+// AnnounceResults posts a match's final score and breakdown. Corrections to
+// an already announced match are marked as such.
+func AnnounceResults(notifier Notifier, db *gorm.DB, match models.QualsMatch, corrected bool) {
+	settings := GetDiscordSettings(db)
+	details := matchAnnouncement(db, "Quals", match)
+	title := render(settings.ResultsTemplate, DefaultResultsTemplate, ResultsAnnouncement{
+		MatchID:   match.ID,
+		RedName:   details.RedName,
+		BlueName:  details.BlueName,
+		RedScore:  match.RedScore,
+		BlueScore: match.BlueScore,
+	})
+	if corrected {
+		title += " (corrected)"
+	}
+
+	color := tieColor
+	if match.RedScore > match.BlueScore {
+		color = redColor
+	} else if match.BlueScore > match.RedScore {
+		color = blueColor
+	}
+	redRP, blueRP := MatchRP(match)
+	breakdown := func(total, auto, teleop, endgame, rp int, surrogate bool) string {
+		earned := fmt.Sprintf("+%d RP", rp)
+		if surrogate {
+			earned = "Surrogate, no RP"
+		}
+		return fmt.Sprintf("**%d** points\nAuto %d · Teleop %d · Endgame %d\n%s", total, auto, teleop, endgame, earned)
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: title,
+		Color: color,
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   "🔴 " + details.RedName,
+				Value:  breakdown(match.RedScore, match.RedAutoScore, match.RedTeleopScore, match.RedEndgameScore, redRP, match.RedSurrogate),
+				Inline: true,
+			},
+			{
+				Name:   "🔵 " + details.BlueName,
+				Value:  breakdown(match.BlueScore, match.BlueAutoScore, match.BlueTeleopScore, match.BlueEndgameScore, blueRP, match.BlueSurrogate),
+				Inline: true,
+			},
+		},
+	}
+	if match.CommittedAt != nil {
+		embed.Timestamp = match.CommittedAt.Format(time.RFC3339)
+	}
+	if err := notifier.SendEmbed(announcementChannel(settings.ResultsChannelID), embed); err != nil {
+		log.Printf("Error announcing results: %v", err)
+	}
+}
+
+// AnnounceRankingsAfter posts the top of the rankings if matchID was the last
+// match of a time block, or of another RankingsEvery matches when the
+// schedule has no blocks, or of qualifications
+func AnnounceRankingsAfter(notifier Notifier, db *gorm.DB, matchID int) {
+	settings := GetDiscordSettings(db)
+	title, due := rankingsDue(db, settings, matchID)
+	if !due {
+		return
+	}
+	AnnounceRankings(notifier, db, title)
+}
+
+func rankingsDue(db *gorm.DB, settings models.DiscordSettings, matchID int) (string, bool) {
+	var ids []int
+	if err := db.Model(&models.QualsMatch{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return "", false
+	}
+	position := -1
+	for i, id := range ids {
+		if id == matchID {
+			position = i
+		}
+	}
+	if position < 0 {
+		return "", false
+	}
+	if position == len(ids)-1 {
+		return "Final qualification rankings", true
+	}
+
+	starts := BlockStarts(db)
+	if len(starts) == 0 {
+		if (position+1)%settings.RankingsEvery == 0 {
+			return "Rankings after Quals " + strconv.Itoa(matchID), true
+		}
+		return "", false
+	}
+	if _, nextStartsBlock := starts[ids[position+1]]; !nextStartsBlock {
+		return "", false
+	}
+
+	// Name the block this match finished
+	var block models.ScheduleBlock
+	for _, id := range ids[:position+1] {
+		if start, ok := starts[id]; ok {
+			block = start
+		}
+	}
+	if block.Label == "" {
+		return "Rankings after Quals " + strconv.Itoa(matchID), true
+	}
+	return "Rankings after " + block.Label, true
+}
+
+// AnnounceRankings posts the top of the leaderboard
+func AnnounceRankings(notifier Notifier, db *gorm.DB, title string) {
+	settings := GetDiscordSettings(db)
+	leaderboard, err := GetLeaderboard(db)
+	if err != nil {
+		log.Printf("Error loading rankings to announce: %v", err)
+		return
+	}
+	if len(leaderboard) > settings.RankingsSize {
+		leaderboard = leaderboard[:settings.RankingsSize]
+	}
+
+	lines := make([]string, 0, len(leaderboard))
+	for _, user := range leaderboard {
+		lines = append(lines, fmt.Sprintf("**%d.** %s — %d RP, %d points", user.Rank, DisplayName(user), user.TotalRP, user.TotalPoints))
+	}
+	embed := &discordgo.MessageEmbed{
+		Title:       "🏆 " + title,
+		Description: strings.Join(lines, "\n"),
+		Color:       rankColor,
+	}
+	if err := notifier.SendEmbed(announcementChannel(settings.RankingsChannelID), embed); err != nil {
+		log.Printf("Error announcing rankings: %v", err)
+	}
+}
+
+// AnnounceAlliancePick posts an alliance selection as it's made
+func AnnounceAlliancePick(notifier Notifier, db *gorm.DB, selection models.AllianceSelection) {
+	settings := GetDiscordSettings(db)
+	message := render(settings.AllianceTemplate, DefaultAllianceTemplate, AllianceAnnouncement{
+		Alliance:  selection.AllianceNumber,
+		Captain:   selection.AllianceCaptain,
+		Selection: selection.AllianceSelection,
+	})
+	if err := notifier.SendMessage(announcementChannel(settings.AllianceChannelID), message); err != nil {
+		log.Printf("Error announcing alliance pick: %v", err)
+	}
+}
+
+// AnnouncePlayoffAdvance posts which alliance moved on from a playoff match
+func AnnouncePlayoffAdvance(notifier Notifier, db *gorm.DB, advance AdvanceAnnouncement) {
+	settings := GetDiscordSettings(db)
+	message := render(settings.AdvanceTemplate, DefaultAdvanceTemplate, advance)
+	if err := notifier.SendMessage(announcementChannel(settings.AllianceChannelID), message); err != nil {
+		log.Printf("Error announcing playoff advancement: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+const (
+	// Time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// Time allowed to read the next pong from the client
+	pongWait = 60 * time.Second
+	// Pings are sent at this interval; must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+	// Largest message a client may send
+	maxMessageSize = 64 * 1024
+	// Messages queued per client before it is considered too slow and dropped
+	sendQueueSize = 256
+)
+
+var (
+	ErrClientClosed = errors.New("websocket client closed")
+	ErrSlowConsumer = errors.New("websocket client send queue full")
+)
+
+type outboundMessage struct {
+	seq  uint64
+	data []byte
+}
+
+// Client is a single subscriber to the feed, either a WebSocket connection
+// or a Server-Sent Events stream (conn is nil). All writes go through the
+// send queue and are performed by the client's own writer goroutine, since
+// gorilla/websocket allows only one concurrent writer per connection.
+type Client struct {
+	conn      *websocket.Conn
+	send      chan outboundMessage
+	done      chan struct{}
+	closeOnce sync.Once
+	role      Role
+
+	topics      map[string]bool
+	topicsMutex sync.RWMutex
+}
+
+func NewClient(conn *websocket.Conn, topics []string, role Role) *Client {
+	client := &Client{
+		conn:   conn,
+		role:   role,
+		send:   make(chan outboundMessage, sendQueueSize),
+		done:   make(chan struct{}),
+		topics: make(map[string]bool),
+	}
+	client.Subscribe(topics...)
+	return client
+}
+
+func (c *Client) Role() Role {
+	return c.role
+}
+
+func (c *Client) Subscribe(topics ...string) {
+	c.topicsMutex.Lock()
+	defer c.topicsMutex.Unlock()
+	for _, topic := range topics {
+		c.topics[topic] = true
+	}
+}
+
+func (c *Client) Unsubscribe(topics ...string) {
+	c.topicsMutex.Lock()
+	defer c.topicsMutex.Unlock()
+	for _, topic := range topics {
+		delete(c.topics, topic)
+	}
+}
+
+// Subscribed reports whether the client wants messages on any of the topics
+func (c *Client) Subscribed(topics ...string) bool {
+	c.topicsMutex.RLock()
+	defer c.topicsMutex.RUnlock()
+	if c.topics[TopicAll] {
+		return true
+	}
+	for _, topic := range topics {
+		if c.topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
+// Topics returns the client's subscriptions in sorted order
+func (c *Client) Topics() []string {
+	c.topicsMutex.RLock()
+	defer c.topicsMutex.RUnlock()
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Send queues a message for the client without blocking. A client whose
+// queue is full is closed so it can't stall the rest of the feed.
+func (c *Client) Send(message models.WebSocketMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(outboundMessage{seq: message.Seq, data: data})
+}
+
+func (c *Client) sendRaw(message outboundMessage) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	select {
+	case c.send <- message:
+		return nil
+	default:
+		c.Close()
+		return ErrSlowConsumer
+	}
+}
+
+// Close stops the client's writer, which closes the underlying connection
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// writePump drains the send queue and keeps the connection alive with pings
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, message.data); err != nil {
+				log.Printf("WebSocket write error: %v", err)
+				c.Close()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("WebSocket ping error: %v", err)
+				c.Close()
+				return
+			}
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
+
+// readPump reads and dispatches client messages until the connection fails
+func (c *Client) readPump(db *gorm.DB) {
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			log.Printf("WebSocket read error: %v", err)
+			return
+		}
+
+		log.Printf("Received message: %s", message)
+
+		spec, payload, err := Protocol.Decode(message)
+		if err != nil {
+			log.Printf("Rejected WebSocket message: %v", err)
+			if err := c.sendProtocolError(err); err != nil {
+				return
+			}
+			continue
+		}
+
+		if !c.role.Allows(spec.Role) {
+			log.Printf("Rejected WebSocket message %s from %s connection", spec.Type, c.role)
+			err := &ProtocolError{Code: ErrCodeForbidden, Message: "connection is not allowed to send this message", RequestType: spec.Type}
+			if err := c.sendProtocolError(err); err != nil {
+				return
+			}
+			continue
+		}
+
+		if err := spec.Handler(c, db, payload); err != nil {
+			if _, ok := err.(*ProtocolError); ok {
+				log.Printf("Rejected WebSocket message: %v", err)
+				if err := c.sendProtocolError(err); err != nil {
+					return
+				}
+				continue
+			}
+			log.Printf("WebSocket handler error for %s: %v", spec.Type, err)
+			return
+		}
+	}
+}
+
+// sendProtocolError replies to a rejected client message
+func (c *Client) sendProtocolError(err error) error {
+	protocolErr, ok := err.(*ProtocolError)
+	if !ok {
+		protocolErr = &ProtocolError{Code: ErrCodeInternal, Message: err.Error()}
+	}
+	return c.Send(NewMessage("error", protocolErr.Payload()))
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// DefaultCycleTime is the time from one match starting to the next starting
+const DefaultCycleTime = 7 * time.Minute
+
+var scheduleTimingMutex sync.Mutex
+var cycle_time = DefaultCycleTime
+var event_start time.Time // Zero until an admin sets when the first match is planned
+
+func GetCycleTime() time.Duration {
+	scheduleTimingMutex.Lock()
+	defer scheduleTimingMutex.Unlock()
+	return cycle_time
+}
+
+func GetEventStart() time.Time {
+	scheduleTimingMutex.Lock()
+	defer scheduleTimingMutex.Unlock()
+	return event_start
+}
+
+// LoadScheduleTiming restores the cycle time and planned start saved by the
+// last SetScheduleTiming, keeping the defaults if there is none
+func LoadScheduleTiming(db *gorm.DB) error {
+	var timings []models.ScheduleTiming
+	if err := db.Limit(1).Find(&timings).Error; err != nil {
+		return err
+	}
+	if len(timings) == 0 {
+		return nil
+	}
+
+	scheduleTimingMutex.Lock()
+	defer scheduleTimingMutex.Unlock()
+	if timings[0].CycleSeconds > 0 {
+		cycle_time = time.Duration(timings[0].CycleSeconds) * time.Second
+	}
+	event_start = time.Time{}
+	if timings[0].EventStart != nil {
+		event_start = *timings[0].EventStart
+	}
+	return nil
+}
+
+// SetScheduleTiming saves the cycle time and planned start of the first
+// match. A zero start plans the schedule from the first committed match.
+func SetScheduleTiming(db *gorm.DB, cycleTime time.Duration, start time.Time) error {
+	timing := models.ScheduleTiming{ID: 1, CycleSeconds: int(cycleTime / time.Second)}
+	if !start.IsZero() {
+		timing.EventStart = &start
+	}
+	if err := db.Save(&timing).Error; err != nil {
+		return err
+	}
+
+	scheduleTimingMutex.Lock()
+	cycle_time = cycleTime
+	event_start = start
+	scheduleTimingMutex.Unlock()
+
+	BroadcastScheduleUpdate(db)
+	return nil
+}
+
+type MatchEstimate struct {
+	MatchID        int        `json:"match_id"`
+	PlannedStart   time.Time  `json:"planned_start"`
+	EstimatedStart time.Time  `json:"estimated_start"`
+	CommittedAt    *time.Time `json:"committed_at,omitempty"`
+}
+
+type ScheduleEstimate struct {
+	FirstMatchStart time.Time              `json:"first_match_start"` // Planned start of the first match
+	CycleSeconds    int                    `json:"cycle_seconds"`
+	DriftSeconds    int64                  `json:"drift_seconds"` // Positive when running behind
+	Estimates       []MatchEstimate        `json:"estimates"`
+	Blocks          []models.ScheduleBlock `json:"blocks"`
+	Breaks          []models.ScheduleBreak `json:"breaks"`
+}
+
+// ByMatch indexes the estimates by match ID for templates
+func (e ScheduleEstimate) ByMatch() map[int]MatchEstimate {
+	byMatch := make(map[int]MatchEstimate, len(e.Estimates))
+	for _, estimate := range e.Estimates {
+		byMatch[estimate.MatchID] = estimate
+	}
+	return byMatch
+}
+
+// EstimateSchedule plans every qualification match and shifts the matches
+// still to be played by how far the event is running ahead or behind,
+// measured at the most recently committed match. Matches with a scheduled
+// start keep it as their plan; the rest are planned one cycle apart.
+func EstimateSchedule(db *gorm.DB) (ScheduleEstimate, error) {
+	cycleTime, start := GetCycleTime(), GetEventStart()
+
+	var matches []models.QualsMatch
+	if err := db.Order("id").Find(&matches).Error; err != nil {
+		return ScheduleEstimate{}, err
+	}
+	blocks, err := GetScheduleBlocks(db)
+	if err != nil {
+		return ScheduleEstimate{}, err
+	}
+	breaks, err := GetScheduleBreaks(db)
+	if err != nil {
+		return ScheduleEstimate{}, err
+	}
+
+	estimate := ScheduleEstimate{
+		CycleSeconds: int(cycleTime / time.Second),
+		Estimates:    make([]MatchEstimate, 0, len(matches)),
+		Blocks:       blocks,
+		Breaks:       breaks,
+	}
+	if len(matches) == 0 {
+		return estimate, nil
+	}
+
+	// How long each match takes, from its block when it has one
+	blockOf := assignBlocks(blocks, len(matches))
+	cycles := make([]time.Duration, len(matches))
+	for i := range matches {
+		cycles[i] = cycleTime
+		if blockOf[i] >= 0 {
+			cycles[i] = time.Duration(blocks[blockOf[i]].CycleSeconds) * time.Second
+		}
+	}
+
+	// Unscheduled matches are planned relative to the first one. Without a
+	// configured start, plan from the first committed match.
+	offsets := make([]time.Duration, len(matches))
+	for i, match := range matches {
+		offsets[i] = time.Duration(i)*cycleTime + breakShift(breaks, match.ID)
+	}
+	lastCommitted := -1
+	for i, match := range matches {
+		if match.CommittedAt == nil {
+			continue
+		}
+		if start.IsZero() && match.ScheduledStart == nil {
+			start = match.CommittedAt.Add(-cycles[i] - offsets[i])
+		}
+		lastCommitted = i
+	}
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	planned := make([]time.Time, len(matches))
+	for i, match := range matches {
+		planned[i] = start.Add(offsets[i])
+		if match.ScheduledStart != nil {
+			planned[i] = *match.ScheduledStart
+		}
+	}
+
+	var drift time.Duration
+	if lastCommitted >= 0 {
+		plannedEnd := planned[lastCommitted].Add(cycles[lastCommitted])
+		drift = matches[lastCommitted].CommittedAt.Sub(plannedEnd)
+	} else if now := time.Now(); now.After(planned[0]) {
+		// Nothing played yet, but the first match is already late
+		drift = now.Sub(planned[0])
+	}
+	estimate.FirstMatchStart = planned[0]
+	estimate.DriftSeconds = int64(drift / time.Second)
+
+	for i, match := range matches {
+		matchEstimate := MatchEstimate{
+			MatchID:        match.ID,
+			PlannedStart:   planned[i],
+			EstimatedStart: planned[i].Add(drift),
+			CommittedAt:    match.CommittedAt,
+		}
+		if match.CommittedAt != nil {
+			// Played matches started one cycle before they were committed
+			matchEstimate.EstimatedStart = match.CommittedAt.Add(-cycles[i])
+		}
+		estimate.Estimates = append(estimate.Estimates, matchEstimate)
+	}
+	return estimate, nil
+}
+
+// EstimatesForPlayer returns the estimates for one player's matches
+func EstimatesForPlayer(db *gorm.DB, mmid int) ([]MatchEstimate, error) {
+	estimate, err := EstimateSchedule(db)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := GetUserMatches(db, mmid)
+	if err != nil {
+		return nil, err
+	}
+
+	byMatch := estimate.ByMatch()
+	estimates := make([]MatchEstimate, 0, len(matches))
+	for _, match := range matches {
+		estimates = append(estimates, byMatch[match.ID])
+	}
+	return estimates, nil
+}
+
+// BroadcastScheduleUpdate sends the drift so schedules can shift their
+// estimates without reloading
+func BroadcastScheduleUpdate(db *gorm.DB) {
+	estimate, err := EstimateSchedule(db)
+	if err != nil {
+		return
+	}
+	Manager.Broadcast(NewMessage("schedule_update", models.WebSocketScheduleUpdatePayload{
+		FirstMatchStart: estimate.FirstMatchStart,
+		CycleSeconds:    estimate.CycleSeconds,
+		DriftSeconds:    estimate.DriftSeconds,
+	}))
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// ExportTable is a downloadable export. It's written either as CSV with a
+// header row or as a JSON array of objects keyed by column.
+type ExportTable struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Exports lists the available exports by name, in the order the admin
+// dashboard shows them
+var Exports = []struct {
+	Name  string
+	Build func(db *gorm.DB) (ExportTable, error)
+}{
+	{"schedule", ExportSchedule},
+	{"schedule_report", ExportScheduleReport},
+	{"results", ExportResults},
+	{"rankings", ExportRankings},
+	{"ranking_history", ExportRankingHistory},
+	{"alliances", ExportAlliances},
+	{"bracket", ExportBracket},
+}
+
+// FindExport looks up an export by name
+func FindExport(name string) (func(db *gorm.DB) (ExportTable, error), bool) {
+	for _, export := range Exports {
+		if export.Name == name {
+			return export.Build, true
+		}
+	}
+	return nil, false
+}
+
+func (t ExportTable) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(t.Columns); err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			record[i] = exportCell(value)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// MarshalJSON keeps each object's keys in column order
+func (t ExportTable) MarshalJSON() ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteByte('[')
+	for i, row := range t.Rows {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		buffer.WriteByte('{')
+		for j, value := range row {
+			if j > 0 {
+				buffer.WriteByte(',')
+			}
+			key, err := json.Marshal(t.Columns[j])
+			if err != nil {
+				return nil, err
+			}
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			buffer.Write(key)
+			buffer.WriteByte(':')
+			buffer.Write(encoded)
+		}
+		buffer.WriteByte('}')
+	}
+	buffer.WriteByte(']')
+	return buffer.Bytes(), nil
+}
+
+// exportCell formats a value for CSV. Missing times are left blank, and text
+// that a spreadsheet would run as a formula, such as a player named
+// =HYPERLINK(...), is prefixed with a quote so it stays text.
+func exportCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// playerNames maps every player's MMID to the name shown for them
+func playerNames(db *gorm.DB) (map[int]string, error) {
+	var users []models.User
+	if err := db.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(users))
+	for _, user := range users {
+		names[user.MMID] = DisplayName(user)
+	}
+	return names, nil
+}
+
+// ExportSchedule lists every qualification match with its players and
+// planned and estimated start
+func ExportSchedule(db *gorm.DB) (ExportTable, error) {
+	names, err := playerNames(db)
+	if err != nil {
+		return ExportTable{}, err
+	}
+	var matches []models.QualsMatch
+	if err := db.Order("id").Find(&matches).Error; err != nil {
+		return ExportTable{}, err
+	}
+	estimate, err := EstimateSchedule(db)
+	if err != nil {
+		return ExportTable{}, err
+	}
+	estimates := estimate.ByMatch()
+
+	table := ExportTable{Columns: []string{
+		"match", "red_mmid", "red_name", "blue_mmid", "blue_name", "scheduled_start", "estimated_start", "red_surrogate", "blue_surrogate",
+	}}
+	for _, match := range matches {
+		var estimated *time.Time
+		if matchEstimate, ok := estimates[match.ID]; ok && !matchEstimate.EstimatedStart.IsZero() {
+			estimated = &matchEstimate.EstimatedStart
+		}
+		table.Rows = append(table.Rows, []interface{}{
+			match.ID,
+			match.RedPlayerID, names[match.RedPlayerID],
+			match.BluePlayerID, names[match.BluePlayerID],
+			match.ScheduledStart, estimated,
+			match.RedSurrogate, match.BlueSurrogate,
+		})
+	}
+	return table, nil
+}
+
+// ExportResults lists every committed qualification match with its score
+// broken down by phase and the ranking points each side earned
+func ExportResults(db *gorm.DB) (ExportTable, error) {
+	names, err := playerNames(db)
+	if err != nil {
+		return ExportTable{}, err
+	}
+	var matches []models.QualsMatch
+	if err := db.Where("committed_at IS NOT NULL").Order("id").Find(&matches).Error; err != nil {
+		return ExportTable{}, err
+	}
+
+	table := ExportTable{Columns: []string{
+		"match", "committed_at", "red_mmid", "red_name", "blue_mmid", "blue_name", "winner",
+		"red_score", "red_auto", "red_teleop", "red_endgame", "red_win_rp", "red_bonus_rp", "red_rp",
+		"blue_score", "blue_auto", "blue_teleop", "blue_endgame", "blue_win_rp", "blue_bonus_rp", "blue_rp",
+		"red_surrogate", "blue_surrogate",
+	}}
+	for _, match := range matches {
+		winner := "tie"
+		if match.RedScore > match.BlueScore {
+			winner = "red"
+		} else if match.BlueScore > match.RedScore {
+			winner = "blue"
+		}
+		redRP, blueRP := MatchRP(match)
+		table.Rows = append(table.Rows, []interface{}{
+			match.ID, match.CommittedAt,
+			match.RedPlayerID, names[match.RedPlayerID],
+			match.BluePlayerID, names[match.BluePlayerID],
+			winner,
+			match.RedScore, match.RedAutoScore, match.RedTeleopScore, match.RedEndgameScore, match.RedWinRP, match.RedBonusRP, redRP,
+			match.BlueScore, match.BlueAutoScore, match.BlueTeleopScore, match.BlueEndgameScore, match.BlueWinRP, match.BlueBonusRP, blueRP,
+			match.RedSurrogate, match.BlueSurrogate,
+		})
+	}
+	return table, nil
+}
+
+// ExportRankings lists the leaderboard with every stat it's ranked on
+func ExportRankings(db *gorm.DB) (ExportTable, error) {
+	leaderboard, err := GetLeaderboard(db)
+	if err != nil {
+		return ExportTable{}, err
+	}
+
+	table := ExportTable{Columns: []string{
+		"rank", "mmid", "name", "total_rp", "win_rp", "bonus_rp", "total_points", "auto_points", "teleop_points", "endgame_points",
+	}}
+	for _, user := range leaderboard {
+		table.Rows = append(table.Rows, []interface{}{
+			user.Rank, user.MMID, DisplayName(user),
+			user.TotalRP, user.WinRP, user.BonusRP,
+			user.TotalPoints, user.AutoPoints, user.TeleopPoints, user.EndgamePoints,
+		})
+	}
+	return table, nil
+}
+
+// ExportAlliances lists each alliance's captain and pick
+func ExportAlliances(db *gorm.DB) (ExportTable, error) {
+	var selections []models.AllianceSelection
+	if err := db.Order("alliance_number").Find(&selections).Error; err != nil {
+		return ExportTable{}, err
+	}
+
+	table := ExportTable{Columns: []string{"alliance", "captain", "captain_mmid", "pick", "pick_mmid"}}
+	for _, selection := range selections {
+		var captainMMID, pickMMID interface{}
+		if captain, ok := findPlayer(db, selection.AllianceCaptain); ok {
+			captainMMID = captain.MMID
+		}
+		if pick, ok := findPlayer(db, selection.AllianceSelection); ok {
+			pickMMID = pick.MMID
+		}
+		table.Rows = append(table.Rows, []interface{}{
+			selection.AllianceNumber,
+			selection.AllianceCaptain, captainMMID,
+			selection.AllianceSelection, pickMMID,
+		})
+	}
+	return table, nil
+}
+
+// ExportBracket lists the playoff results in the order they were saved
+func ExportBracket(db *gorm.DB) (ExportTable, error) {
+	var matches []models.PlayoffMatch
+	if err := db.Order("id").Find(&matches).Error; err != nil {
+		return ExportTable{}, err
+	}
+
+	table := ExportTable{Columns: []string{"match", "red_alliance", "blue_alliance", "red_score", "blue_score", "winner"}}
+	for _, match := range matches {
+		var winner interface{} = "tie"
+		if match.RedScore > match.BlueScore {
+			winner = match.RedAlliance
+		} else if match.BlueScore > match.RedScore {
+			winner = match.BlueAlliance
+		}
+		table.Rows = append(table.Rows, []interface{}{
+			match.Name, match.RedAlliance, match.BlueAlliance, match.RedScore, match.BlueScore, winner,
+		})
+	}
+	return table, nil
+}
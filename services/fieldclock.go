@@ -0,0 +1,385 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+type FieldState string
+
+const (
+	FieldIdle         FieldState = "idle"
+	FieldStaged       FieldState = "staged"
+	FieldAuto         FieldState = "auto"
+	FieldTransition   FieldState = "transition"
+	FieldTeleop       FieldState = "teleop"
+	FieldEndgame      FieldState = "endgame"
+	FieldEnded        FieldState = "ended"
+	FieldScoresPosted FieldState = "scores_posted"
+)
+
+// How often clock ticks are broadcast on the timer topic
+const clockTickPeriod = time.Second
+
+// MatchTiming holds the length of each timed period of a match
+type MatchTiming struct {
+	Auto       time.Duration
+	Transition time.Duration
+	Teleop     time.Duration
+	Endgame    time.Duration
+}
+
+var DefaultMatchTiming = MatchTiming{
+	Auto:       15 * time.Second,
+	Transition: 3 * time.Second,
+	Teleop:     115 * time.Second,
+	Endgame:    20 * time.Second,
+}
+
+func (t MatchTiming) Validate() error {
+	if t.Auto <= 0 || t.Teleop <= 0 {
+		return fmt.Errorf("auto and teleop must be longer than zero")
+	}
+	if t.Transition < 0 || t.Endgame < 0 {
+		return fmt.Errorf("transition and endgame can't be negative")
+	}
+	return nil
+}
+
+// Total is the length of a match from the start of auto to the final buzzer
+func (t MatchTiming) Total() time.Duration {
+	return t.Auto + t.Transition + t.Teleop + t.Endgame
+}
+
+// duration returns how long a timed state lasts and the state that follows it
+func (t MatchTiming) duration(state FieldState) (time.Duration, FieldState) {
+	switch state {
+	case FieldAuto:
+		return t.Auto, FieldTransition
+	case FieldTransition:
+		return t.Transition, FieldTeleop
+	case FieldTeleop:
+		return t.Teleop, FieldEndgame
+	case FieldEndgame:
+		return t.Endgame, FieldEnded
+	default:
+		return 0, state
+	}
+}
+
+// FieldClock runs the field state machine for the match on the field:
+// idle → staged → auto → transition → teleop → endgame → ended → scores posted
+type FieldClock struct {
+	mutex sync.Mutex
+
+	state      FieldState
+	timing     MatchTiming
+	matchLevel string
+	matchID    int
+
+	periodEnds time.Time     // When the current timed period ends, if running
+	remaining  time.Duration // Time left in the current period while paused
+	paused     bool
+	stop       chan struct{} // Closed to stop the ticking goroutine
+	lastStatus atomic.Pointer[models.WebSocketMatchClockPayload]
+}
+
+func NewFieldClock(timing MatchTiming) *FieldClock {
+	field := &FieldClock{state: FieldIdle, timing: timing}
+	status := field.statusLocked()
+	field.lastStatus.Store(&status)
+	return field
+}
+
+// Field is the clock for the one field this event runs on
+var Field = NewFieldClock(DefaultMatchTiming)
+
+func (f *FieldClock) running() bool {
+	switch f.state {
+	case FieldAuto, FieldTransition, FieldTeleop, FieldEndgame:
+		return true
+	}
+	return false
+}
+
+// Stage puts a match on the field ready to start
+func (f *FieldClock) Stage(matchLevel string, matchID int) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.running() {
+		return fmt.Errorf("match %s %d is still running", f.matchLevel, f.matchID)
+	}
+	f.state = FieldStaged
+	f.matchLevel = matchLevel
+	f.matchID = matchID
+	f.paused = false
+	f.broadcastLocked(TopicMatch, TopicTimer)
+	return nil
+}
+
+// Start begins auto for the staged match
+func (f *FieldClock) Start() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.state != FieldStaged {
+		return fmt.Errorf("field must be staged to start, it is %s", f.state)
+	}
+	f.enterLocked(FieldAuto, time.Now())
+	f.stop = make(chan struct{})
+	go f.tick(f.stop)
+	f.broadcastLocked(TopicMatch, TopicTimer)
+	return nil
+}
+
+func (f *FieldClock) Pause() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.running() || f.paused {
+		return fmt.Errorf("field is not running")
+	}
+	f.remaining = time.Until(f.periodEnds)
+	f.paused = true
+	f.broadcastLocked(TopicMatch, TopicTimer)
+	return nil
+}
+
+func (f *FieldClock) Resume() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.running() || !f.paused {
+		return fmt.Errorf("field is not paused")
+	}
+	f.periodEnds = time.Now().Add(f.remaining)
+	f.paused = false
+	f.broadcastLocked(TopicMatch, TopicTimer)
+	return nil
+}
+
+// Abort stops the match without a result and returns it to staged so it can
+// be replayed
+func (f *FieldClock) Abort() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.running() {
+		return fmt.Errorf("field is not running")
+	}
+	f.stopLocked()
+	f.state = FieldStaged
+	f.paused = false
+	log.Printf("Aborted %s %d", f.matchLevel, f.matchID)
+	f.broadcastLocked(TopicMatch, TopicTimer)
+	return nil
+}
+
+// Reset clears the field back to idle
+func (f *FieldClock) Reset() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.stopLocked()
+	f.state = FieldIdle
+	f.matchLevel = ""
+	f.matchID = 0
+	f.paused = false
+	f.broadcastLocked(TopicMatch, TopicTimer)
+}
+
+// Stop halts the ticking goroutine for shutdown, leaving the state as it is
+func (f *FieldClock) Stop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.stopLocked()
+}
+
+// PostScores records that scores were committed for a match. If it is the
+// match on the field, the field moves to scores posted and the end screen is
+// shown. It reports whether the end screen was triggered.
+func (f *FieldClock) PostScores(matchLevel string, matchID int, saved models.WebSocketMatchSavedPayload) bool {
+	f.mutex.Lock()
+	if f.matchLevel != matchLevel || f.matchID != matchID || f.state == FieldIdle || f.state == FieldScoresPosted {
+		f.mutex.Unlock()
+		return false
+	}
+	f.stopLocked()
+	f.state = FieldScoresPosted
+	f.paused = false
+	f.broadcastLocked(TopicMatch, TopicTimer)
+	f.mutex.Unlock()
+
+	EndScreenBroadcast(saved)
+	return true
+}
+
+func (f *FieldClock) Timing() MatchTiming {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.timing
+}
+
+// SetTiming changes the period lengths. It can't be changed mid-match.
+func (f *FieldClock) SetTiming(timing MatchTiming) error {
+	if err := timing.Validate(); err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.running() {
+		return fmt.Errorf("can't change timing while a match is running")
+	}
+	f.timing = timing
+	f.broadcastLocked(TopicTimer)
+	return nil
+}
+
+// Status returns the clock as sent to clients
+func (f *FieldClock) Status() models.WebSocketMatchClockPayload {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.statusLocked()
+}
+
+func (f *FieldClock) statusLocked() models.WebSocketMatchClockPayload {
+	status := models.WebSocketMatchClockPayload{
+		State:             string(f.state),
+		MatchLevel:        f.matchLevel,
+		MatchID:           f.matchID,
+		Paused:            f.paused,
+		AutoSeconds:       int(f.timing.Auto / time.Second),
+		TransitionSeconds: int(f.timing.Transition / time.Second),
+		TeleopSeconds:     int(f.timing.Teleop / time.Second),
+		EndgameSeconds:    int(f.timing.Endgame / time.Second),
+	}
+
+	if f.running() {
+		periodLength, _ := f.timing.duration(f.state)
+		remaining := f.remaining
+		if !f.paused {
+			remaining = time.Until(f.periodEnds)
+		}
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		// Everything after the current period still has to be played
+		matchRemaining := remaining
+		for _, next := f.timing.duration(f.state); next != FieldEnded; _, next = f.timing.duration(next) {
+			length, _ := f.timing.duration(next)
+			matchRemaining += length
+		}
+
+		status.PeriodDurationMs = periodLength.Milliseconds()
+		status.PeriodRemainingMs = remaining.Milliseconds()
+		status.MatchRemainingMs = matchRemaining.Milliseconds()
+	}
+	return status
+}
+
+// enterLocked starts a timed period, skipping periods with no length
+func (f *FieldClock) enterLocked(state FieldState, start time.Time) {
+	for {
+		length, next := f.timing.duration(state)
+		if state == FieldEnded || length > 0 {
+			f.state = state
+			f.periodEnds = start.Add(length)
+			return
+		}
+		state = next
+	}
+}
+
+func (f *FieldClock) stopLocked() {
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+}
+
+// tick advances the clock until the match ends or is stopped. It wakes every
+// clockTickPeriod, or sooner when a period is about to end.
+func (f *FieldClock) tick(stop chan struct{}) {
+	timer := time.NewTimer(clockTickPeriod)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		f.mutex.Lock()
+		if f.stop != stop {
+			// The match was stopped while we were waiting for the lock
+			f.mutex.Unlock()
+			return
+		}
+		if f.paused {
+			f.mutex.Unlock()
+			timer.Reset(clockTickPeriod)
+			continue
+		}
+
+		changed := false
+		now := time.Now()
+		for f.running() && !now.Before(f.periodEnds) {
+			_, next := f.timing.duration(f.state)
+			f.enterLocked(next, f.periodEnds)
+			changed = true
+		}
+
+		if changed {
+			log.Printf("Field entered %s for %s %d", f.state, f.matchLevel, f.matchID)
+			f.broadcastLocked(TopicMatch, TopicTimer)
+		} else {
+			f.broadcastTickLocked()
+		}
+
+		if f.state == FieldEnded {
+			f.stop = nil
+			f.mutex.Unlock()
+			return
+		}
+
+		wait := clockTickPeriod
+		if untilEnd := time.Until(f.periodEnds); untilEnd < wait {
+			wait = untilEnd
+		}
+		f.mutex.Unlock()
+		timer.Reset(wait)
+	}
+}
+
+// broadcastLocked sends the clock and remembers it for snapshots. Snapshots
+// read lastStatus rather than taking the mutex, so the hub never waits on the
+// clock while the clock is waiting on the hub.
+func (f *FieldClock) broadcastLocked(topics ...string) {
+	status := f.statusLocked()
+	f.lastStatus.Store(&status)
+	Manager.BroadcastTo(topics, NewMessage("match_clock", status))
+}
+
+// broadcastTickLocked sends the clock between state changes. Ticks aren't
+// sequenced or replayed; a resuming client gets the next one a second later.
+func (f *FieldClock) broadcastTickLocked() {
+	status := f.statusLocked()
+	f.lastStatus.Store(&status)
+	Manager.BroadcastUnsequenced([]string{TopicTimer}, NewMessage("match_clock", status))
+}
+
+// LastStatus returns the clock as of its most recent broadcast
+func (f *FieldClock) LastStatus() models.WebSocketMatchClockPayload {
+	return *f.lastStatus.Load()
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// GuildManager creates and removes the roles and channels the event uses in
+// its Discord server
+type GuildManager interface {
+	CreateRole(name string) (string, error)
+	DeleteRole(roleID string) error
+	AddMemberRole(userID string, roleID string) error
+	RemoveMemberRole(userID string, roleID string) error
+	CreateChannel(channel GuildChannel) (string, error)
+	DeleteChannel(channelID string) error
+}
+
+// GuildChannel describes a channel to create. Only members with one of
+// RoleIDs or a staff role can see it.
+type GuildChannel struct {
+	Name     string
+	Type     discordgo.ChannelType
+	ParentID string // Category to create the channel in
+	RoleIDs  []string
+}
+
+var ErrNoGuild = errors.New("no Discord server is connected")
+
+// DiscordGuild manages the server in DISCORD_GUILD_ID through a connected bot
+// session
+type DiscordGuild struct {
+	session *discordgo.Session
+	guildID string
+}
+
+func NewDiscordGuild(session *discordgo.Session, guildID string) *DiscordGuild {
+	return &DiscordGuild{session: session, guildID: guildID}
+}
+
+func (d *DiscordGuild) CreateRole(name string) (string, error) {
+	role, err := d.session.GuildRoleCreate(d.guildID, &discordgo.RoleParams{Name: name})
+	if err != nil {
+		return "", err
+	}
+	return role.ID, nil
+}
+
+func (d *DiscordGuild) DeleteRole(roleID string) error {
+	return ignoreMissing(d.session.GuildRoleDelete(d.guildID, roleID))
+}
+
+func (d *DiscordGuild) AddMemberRole(userID string, roleID string) error {
+	return d.session.GuildMemberRoleAdd(d.guildID, userID, roleID)
+}
+
+func (d *DiscordGuild) RemoveMemberRole(userID string, roleID string) error {
+	return d.session.GuildMemberRoleRemove(d.guildID, userID, roleID)
+}
+
+func (d *DiscordGuild) CreateChannel(channel GuildChannel) (string, error) {
+	access := int64(discordgo.PermissionViewChannel | discordgo.PermissionSendMessages |
+		discordgo.PermissionReadMessageHistory | discordgo.PermissionVoiceConnect | discordgo.PermissionVoiceSpeak)
+
+	// The @everyone role shares the server's ID
+	overwrites := []*discordgo.PermissionOverwrite{{
+		ID:   d.guildID,
+		Type: discordgo.PermissionOverwriteTypeRole,
+		Deny: discordgo.PermissionViewChannel,
+	}}
+	roleIDs := append(append([]string(nil), channel.RoleIDs...), StaffRoleIDs()...)
+	for _, roleID := range roleIDs {
+		overwrites = append(overwrites, &discordgo.PermissionOverwrite{
+			ID:    roleID,
+			Type:  discordgo.PermissionOverwriteTypeRole,
+			Allow: access,
+		})
+	}
+
+	created, err := d.session.GuildChannelCreateComplex(d.guildID, discordgo.GuildChannelCreateData{
+		Name:                 channel.Name,
+		Type:                 channel.Type,
+		ParentID:             channel.ParentID,
+		PermissionOverwrites: overwrites,
+	})
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (d *DiscordGuild) DeleteChannel(channelID string) error {
+	_, err := d.session.ChannelDelete(channelID)
+	return ignoreMissing(err)
+}
+
+// NoopGuild is used without a Discord server. Every change fails so the admin
+// page says why nothing happened.
+type NoopGuild struct{}
+
+func (NoopGuild) CreateRole(name string) (string, error) {
+	return "", ErrNoGuild
+}
+
+func (NoopGuild) DeleteRole(roleID string) error {
+	return ErrNoGuild
+}
+
+func (NoopGuild) AddMemberRole(userID string, roleID string) error {
+	return ErrNoGuild
+}
+
+func (NoopGuild) RemoveMemberRole(userID string, roleID string) error {
+	return ErrNoGuild
+}
+
+func (NoopGuild) CreateChannel(channel GuildChannel) (string, error) {
+	return "", ErrNoGuild
+}
+
+func (NoopGuild) DeleteChannel(channelID string) error {
+	return ErrNoGuild
+}
+
+// StaffRoleIDs reads the roles in DISCORD_STAFF_ROLE_IDS
+func StaffRoleIDs() []string {
+	return splitEnvList("DISCORD_STAFF_ROLE_IDS")
+}
+
+// ignoreMissing treats a role or channel someone already deleted by hand as
+// gone rather than as a failure
+func ignoreMissing(err error) error {
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,337 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/bwmarrin/discordgo"
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+const (
+	resourceRole    = "role"
+	resourceChannel = "channel"
+
+	// playoffsPurpose is the category holding the alliance channels
+	playoffsPurpose = "playoffs"
+)
+
+// roleRun makes one batch of role and channel changes, or in a dry run only
+// lists them
+type roleRun struct {
+	db      *gorm.DB
+	guild   GuildManager
+	dryRun  bool
+	actions []string
+	planned map[string]bool // Purposes a dry run would have created
+}
+
+// step records an action and carries it out unless this is a dry run
+func (r *roleRun) step(action string, do func() error) error {
+	r.actions = append(r.actions, action)
+	if r.dryRun {
+		return nil
+	}
+	return do()
+}
+
+// existing finds the role or channel created for a purpose
+func (r *roleRun) existing(purpose string) (models.DiscordResource, bool) {
+	var resource models.DiscordResource
+	if err := r.db.Where("purpose = ?", purpose).Limit(1).Find(&resource).Error; err != nil || resource.ID == 0 {
+		return models.DiscordResource{}, false
+	}
+	return resource, true
+}
+
+// resource returns the Discord ID of the role or channel for a purpose,
+// creating it the first time. A dry run returns an empty ID for anything it
+// would have created.
+func (r *roleRun) resource(purpose string, kind string, name string, create func() (string, error)) (string, error) {
+	if resource, ok := r.existing(purpose); ok {
+		return resource.DiscordID, nil
+	}
+	if r.planned[purpose] {
+		return "", nil
+	}
+	if r.dryRun {
+		if r.planned == nil {
+			r.planned = make(map[string]bool)
+		}
+		r.planned[purpose] = true
+	}
+
+	var id string
+	err := r.step(fmt.Sprintf("Create %s %q", kind, name), func() error {
+		var err error
+		if id, err = create(); err != nil {
+			return err
+		}
+		return r.db.Create(&models.DiscordResource{Purpose: purpose, Kind: kind, Name: name, DiscordID: id}).Error
+	})
+	return id, err
+}
+
+func (r *roleRun) role(purpose string, name string) (string, error) {
+	return r.resource(purpose, resourceRole, name, func() (string, error) {
+		return r.guild.CreateRole(name)
+	})
+}
+
+func (r *roleRun) channel(purpose string, channel GuildChannel) (string, error) {
+	return r.resource(purpose, resourceChannel, channel.Name, func() (string, error) {
+		return r.guild.CreateChannel(channel)
+	})
+}
+
+func (r *roleRun) grant(user models.User, roleID string, roleName string) error {
+	return r.step(fmt.Sprintf("Give %s the %q role", DisplayName(user), roleName), func() error {
+		return r.guild.AddMemberRole(strconv.Itoa(user.ID), roleID)
+	})
+}
+
+func (r *roleRun) revoke(user models.User, roleID string, roleName string) error {
+	return r.step(fmt.Sprintf("Take the %q role from %s", roleName, DisplayName(user)), func() error {
+		return r.guild.RemoveMemberRole(strconv.Itoa(user.ID), roleID)
+	})
+}
+
+// eventRoleName prefixes a role or category with the event name so roles
+// from past events are easy to tell apart
+func eventRoleName(name string) string {
+	if eventName := GetEventName(); eventName != "" {
+		return eventName + " " + name
+	}
+	return name
+}
+
+func participantRoleName() string {
+	return eventRoleName("Participant")
+}
+
+func allianceRoleName(alliance int) string {
+	return eventRoleName(fmt.Sprintf("Alliance %d", alliance))
+}
+
+// findPlayer looks up a player by the name used in alliance selection
+func findPlayer(db *gorm.DB, name string) (models.User, bool) {
+	if name == "" {
+		return models.User{}, false
+	}
+	var user models.User
+	if err := db.Where("prefered_username = ? OR (prefered_username = '' AND username = ?)", name, name).First(&user).Error; err != nil {
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// GrantParticipantRole gives a registered player the event's participant role
+func GrantParticipantRole(db *gorm.DB, guild GuildManager, user models.User, dryRun bool) ([]string, error) {
+	run := &roleRun{db: db, guild: guild, dryRun: dryRun}
+	err := run.grantParticipant(user)
+	return run.actions, err
+}
+
+func (r *roleRun) grantParticipant(user models.User) error {
+	roleID, err := r.role("participant", participantRoleName())
+	if err != nil {
+		return err
+	}
+	return r.grant(user, roleID, participantRoleName())
+}
+
+// GrantAllianceRoles gives an alliance's captain and pick its role, and takes
+// it back from anyone the previous selection had who isn't on it anymore
+func GrantAllianceRoles(db *gorm.DB, guild GuildManager, selection models.AllianceSelection, previous models.AllianceSelection, dryRun bool) ([]string, error) {
+	run := &roleRun{db: db, guild: guild, dryRun: dryRun}
+	err := run.grantAlliance(selection, previous)
+	return run.actions, err
+}
+
+func (r *roleRun) grantAlliance(selection models.AllianceSelection, previous models.AllianceSelection) error {
+	purpose := fmt.Sprintf("alliance-%d", selection.AllianceNumber)
+	name := allianceRoleName(selection.AllianceNumber)
+	members := map[string]bool{selection.AllianceCaptain: true, selection.AllianceSelection: true}
+	kept := map[string]bool{} // Already have the role from the previous selection
+
+	if resource, ok := r.existing(purpose); ok {
+		for _, earlier := range []string{previous.AllianceCaptain, previous.AllianceSelection} {
+			user, found := findPlayer(r.db, earlier)
+			if !found {
+				continue
+			}
+			if members[earlier] {
+				kept[earlier] = true
+				continue
+			}
+			if err := r.revoke(user, resource.DiscordID, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, member := range []string{selection.AllianceCaptain, selection.AllianceSelection} {
+		user, found := findPlayer(r.db, member)
+		if !found || kept[member] {
+			continue
+		}
+		roleID, err := r.role(purpose, name)
+		if err != nil {
+			return err
+		}
+		if err := r.grant(user, roleID, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncEventRoles grants every role players should already have, e.g. after
+// turning role automation on partway through the event
+func SyncEventRoles(db *gorm.DB, guild GuildManager, dryRun bool) ([]string, error) {
+	run := &roleRun{db: db, guild: guild, dryRun: dryRun}
+
+	var users []models.User
+	if err := db.Order("mm_id").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		if err := run.grantParticipant(user); err != nil {
+			return run.actions, err
+		}
+	}
+
+	var selections []models.AllianceSelection
+	if err := db.Order("alliance_number").Find(&selections).Error; err != nil {
+		return run.actions, err
+	}
+	for _, selection := range selections {
+		if err := run.grantAlliance(selection, models.AllianceSelection{}); err != nil {
+			return run.actions, err
+		}
+	}
+	return run.actions, nil
+}
+
+// CreatePlayoffChannels makes a private text and voice channel for each
+// selected alliance, grouped under a playoffs category
+func CreatePlayoffChannels(db *gorm.DB, guild GuildManager, dryRun bool) ([]string, error) {
+	run := &roleRun{db: db, guild: guild, dryRun: dryRun}
+
+	var selections []models.AllianceSelection
+	if err := db.Where("alliance_captain <> ''").Order("alliance_number").Find(&selections).Error; err != nil {
+		return nil, err
+	}
+
+	categoryID, err := run.channel(playoffsPurpose, GuildChannel{
+		Name: eventRoleName("Playoffs"),
+		Type: discordgo.ChannelTypeGuildCategory,
+	})
+	if err != nil {
+		return run.actions, err
+	}
+
+	for _, selection := range selections {
+		alliance := selection.AllianceNumber
+		roleID, err := run.role(fmt.Sprintf("alliance-%d", alliance), allianceRoleName(alliance))
+		if err != nil {
+			return run.actions, err
+		}
+
+		channels := []struct {
+			suffix  string
+			channel GuildChannel
+		}{
+			{"text", GuildChannel{Name: fmt.Sprintf("alliance-%d", alliance), Type: discordgo.ChannelTypeGuildText}},
+			{"voice", GuildChannel{Name: fmt.Sprintf("Alliance %d", alliance), Type: discordgo.ChannelTypeGuildVoice}},
+		}
+		for _, c := range channels {
+			c.channel.ParentID = categoryID
+			c.channel.RoleIDs = []string{roleID}
+			if _, err := run.channel(fmt.Sprintf("alliance-%d-%s", alliance, c.suffix), c.channel); err != nil {
+				return run.actions, err
+			}
+		}
+	}
+	return run.actions, nil
+}
+
+// ArchiveEventDiscord deletes every role and channel the bot created for the
+// event. Channels go before their category, and roles go last.
+func ArchiveEventDiscord(db *gorm.DB, guild GuildManager, dryRun bool) ([]string, error) {
+	run := &roleRun{db: db, guild: guild, dryRun: dryRun}
+
+	var resources []models.DiscordResource
+	if err := db.Order("id").Find(&resources).Error; err != nil {
+		return nil, err
+	}
+
+	var channels, categories, roles []models.DiscordResource
+	for _, resource := range resources {
+		switch {
+		case resource.Kind == resourceRole:
+			roles = append(roles, resource)
+		case resource.Purpose == playoffsPurpose:
+			categories = append(categories, resource)
+		default:
+			channels = append(channels, resource)
+		}
+	}
+
+	for _, group := range [][]models.DiscordResource{channels, categories, roles} {
+		for _, resource := range group {
+			err := run.step(fmt.Sprintf("Delete %s %q", resource.Kind, resource.Name), func() error {
+				var err error
+				if resource.Kind == resourceRole {
+					err = guild.DeleteRole(resource.DiscordID)
+				} else {
+					err = guild.DeleteChannel(resource.DiscordID)
+				}
+				if err != nil {
+					return err
+				}
+				return db.Delete(&resource).Error
+			})
+			if err != nil {
+				return run.actions, err
+			}
+		}
+	}
+	return run.actions, nil
+}
+
+// AutomateRegistration grants a new player the participant role when role
+// automation is on
+func AutomateRegistration(db *gorm.DB, guild GuildManager, user models.User) {
+	settings := GetDiscordSettings(db)
+	if !settings.ManageRoles {
+		return
+	}
+	actions, err := GrantParticipantRole(db, guild, user, settings.RolesDryRun)
+	logRoleActions(actions, settings.RolesDryRun, err)
+}
+
+// AutomateAllianceSelection keeps alliance roles in step with a saved
+// selection when role automation is on
+func AutomateAllianceSelection(db *gorm.DB, guild GuildManager, selection models.AllianceSelection, previous models.AllianceSelection) {
+	settings := GetDiscordSettings(db)
+	if !settings.ManageRoles {
+		return
+	}
+	actions, err := GrantAllianceRoles(db, guild, selection, previous, settings.RolesDryRun)
+	logRoleActions(actions, settings.RolesDryRun, err)
+}
+
+func logRoleActions(actions []string, dryRun bool, err error) {
+	if dryRun {
+		for _, action := range actions {
+			log.Printf("Dry run: %s", action)
+		}
+	}
+	if err != nil {
+		log.Printf("Error updating Discord roles: %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// Number of recent broadcasts kept for clients resuming after a reconnect
+const replayBufferSize = 512
+
+type bufferedMessage struct {
+	outboundMessage
+	topics []string
+}
+
+// ConnectionManager is the hub behind both the WebSocket and Server-Sent
+// Events feeds
+type ConnectionManager struct {
+	connections map[*Client]bool
+	mutex       sync.RWMutex
+
+	// broadcastMutex orders sequence numbers, the replay buffer and fan-out so
+	// every client sees broadcasts in sequence order
+	broadcastMutex sync.Mutex
+	seq            uint64
+	history        []bufferedMessage
+}
+
+// Sequence numbers start at the startup time in milliseconds, so a client
+// holding a sequence number from before a restart is always behind the
+// replay buffer and falls back to a snapshot.
+var Manager = &ConnectionManager{
+	connections: make(map[*Client]bool),
+	seq:         uint64(time.Now().UnixMilli()),
+}
+
+// Add connection to manager
+func (cm *ConnectionManager) AddConnection(client *Client) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	cm.connections[client] = true
+	log.Printf("WebSocket connection added. Total connections: %d", len(cm.connections))
+}
+
+// Remove connection from manager
+func (cm *ConnectionManager) RemoveConnection(client *Client) {
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+	if _, ok := cm.connections[client]; !ok {
+		return
+	}
+	delete(cm.connections, client)
+	log.Printf("WebSocket connection removed. Total connections: %d", len(cm.connections))
+}
+
+// CloseAll ends every WebSocket connection and event stream, for shutdown.
+// Their handlers return as they see the client close.
+func (cm *ConnectionManager) CloseAll() {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	for client := range cm.connections {
+		client.Close()
+	}
+	log.Printf("Closing %d WebSocket connections", len(cm.connections))
+}
+
+// Broadcast message to the clients subscribed to its registered topic
+func (cm *ConnectionManager) Broadcast(message models.WebSocketMessage) {
+	topic := TopicAll
+	if spec, ok := Protocol.Lookup(message.Type); ok && spec.Topic != "" {
+		topic = spec.Topic
+	}
+	cm.BroadcastTo([]string{topic}, message)
+}
+
+// BroadcastTo sends message to every client subscribed to any of the topics.
+// The message is stamped with the next sequence number and kept in the replay
+// buffer. It is queued on each client's send queue; clients that can't keep
+// up are dropped.
+func (cm *ConnectionManager) BroadcastTo(topics []string, message models.WebSocketMessage) {
+	cm.broadcastMutex.Lock()
+	defer cm.broadcastMutex.Unlock()
+
+	message.Seq = cm.seq + 1
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error encoding broadcast %s: %v", message.Type, err)
+		return
+	}
+	cm.seq = message.Seq
+
+	outbound := outboundMessage{seq: message.Seq, data: data}
+	cm.history = append(cm.history, bufferedMessage{outboundMessage: outbound, topics: topics})
+	if len(cm.history) > replayBufferSize {
+		cm.history = append(cm.history[:0], cm.history[len(cm.history)-replayBufferSize:]...)
+	}
+
+	cm.mutex.RLock()
+	clients := make([]*Client, 0, len(cm.connections))
+	for client := range cm.connections {
+		if client.Subscribed(topics...) {
+			clients = append(clients, client)
+		}
+	}
+	cm.mutex.RUnlock()
+
+	for _, client := range clients {
+		if err := client.sendRaw(outbound); err != nil {
+			log.Printf("Error broadcasting to client: %v", err)
+			cm.RemoveConnection(client)
+		}
+	}
+}
+
+// BroadcastUnsequenced sends a message that is superseded by the next one of
+// its kind, such as a clock tick, to every client subscribed to any of the
+// topics. It gets no sequence number and isn't kept for replay, so frequent
+// messages don't push state changes out of the replay buffer.
+func (cm *ConnectionManager) BroadcastUnsequenced(topics []string, message models.WebSocketMessage) {
+	cm.broadcastMutex.Lock()
+	defer cm.broadcastMutex.Unlock()
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error encoding broadcast %s: %v", message.Type, err)
+		return
+	}
+	outbound := outboundMessage{data: data}
+
+	cm.mutex.RLock()
+	clients := make([]*Client, 0, len(cm.connections))
+	for client := range cm.connections {
+		if client.Subscribed(topics...) {
+			clients = append(clients, client)
+		}
+	}
+	cm.mutex.RUnlock()
+
+	for _, client := range clients {
+		if err := client.sendRaw(outbound); err != nil {
+			log.Printf("Error broadcasting to client: %v", err)
+			cm.RemoveConnection(client)
+		}
+	}
+}
+
+// Resume replays the broadcasts after lastSeq that the client is subscribed
+// to. It returns false when the client needs a snapshot instead: the replay
+// buffer no longer covers lastSeq, or the replay wouldn't fit in the client's
+// send queue and would drop it as a slow consumer.
+func (cm *ConnectionManager) Resume(client *Client, lastSeq uint64) (bool, error) {
+	cm.broadcastMutex.Lock()
+	defer cm.broadcastMutex.Unlock()
+
+	if lastSeq > cm.seq {
+		return false, nil
+	}
+	if lastSeq == cm.seq {
+		return true, nil
+	}
+	if len(cm.history) == 0 || cm.history[0].seq > lastSeq+1 {
+		return false, nil
+	}
+
+	var replay []outboundMessage
+	for _, message := range cm.history {
+		if message.seq > lastSeq && client.Subscribed(message.topics...) {
+			replay = append(replay, message.outboundMessage)
+		}
+	}
+	if len(replay) > cap(client.send)-len(client.send) {
+		return false, nil
+	}
+
+	for _, message := range replay {
+		if err := client.sendRaw(message); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// SendSnapshot sends the client the full show state as of the latest
+// sequence number
+func (cm *ConnectionManager) SendSnapshot(client *Client) error {
+	cm.broadcastMutex.Lock()
+	defer cm.broadcastMutex.Unlock()
+
+	message := NewMessage("state_snapshot", BuildShowState(cm.seq))
+	message.Seq = cm.seq
+	return client.Send(message)
+}
@@ -1,15 +1,19 @@
 package services
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"gorm.io/gorm"
 
-	"github.com/Jake-Schuler/MoSim-Event-Manager/config"
 	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/repository"
 )
 
 var CurrentMMID = 1
@@ -28,107 +32,189 @@ func GetMMID(db *gorm.DB) {
 	}
 }
 
-func ParseMatchSchedule() []map[string]interface{} {
-	db := config.InitDB()
-	var matches []map[string]interface{}
+// GeneratedMatch is a match of MatchMaker's schedule, by MMID
+type GeneratedMatch struct {
+	Red           int
+	Blue          int
+	RedSurrogate  bool
+	BlueSurrogate bool
+}
 
-	if _, err := os.Stat("match_schedule.txt"); err != nil {
-		return matches
+// ParseMatchMakerOutput reads each match from MatchMaker's schedule output,
+// which lists two matches to a line. Each player is followed by a 1 when
+// they're playing as a surrogate.
+func ParseMatchMakerOutput(output []byte) ([]GeneratedMatch, error) {
+	var matches []GeneratedMatch
+	for number, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			continue
+		}
+
+		// The players are the 2nd and 4th numbers, then the 6th and 8th
+		for _, columns := range [][2]int{{1, 3}, {5, 7}} {
+			red, redErr := strconv.Atoi(fields[columns[0]])
+			blue, blueErr := strconv.Atoi(fields[columns[1]])
+			if redErr != nil || blueErr != nil {
+				return nil, fmt.Errorf("line %d of the MatchMaker output isn't a schedule: %q", number+1, line)
+			}
+			matches = append(matches, GeneratedMatch{
+				Red:           red,
+				Blue:          blue,
+				RedSurrogate:  surrogateFlag(fields, columns[0]+1),
+				BlueSurrogate: surrogateFlag(fields, columns[1]+1),
+			})
+		}
 	}
+	if len(matches) == 0 {
+		return nil, errors.New("MatchMaker output has no matches")
+	}
+	return matches, nil
+}
 
-	data, err := os.ReadFile("match_schedule.txt")
+func surrogateFlag(fields []string, i int) bool {
+	return i < len(fields) && fields[i] == "1"
+}
+
+// GenerateOptions says what to do with a generated schedule
+type GenerateOptions struct {
+	Append  bool // Add the new rounds after the last existing match
+	Force   bool // Replace the schedule even though matches have been played
+	Preview bool // Only report what the schedule would be
+}
+
+// GenerateSchedule saves generated matches in a single transaction.
+// Replacing the schedule throws away every result, so it's refused once a
+// match has been played unless forced; appending keeps every existing
+// match. Nothing changes if the preview has conflicts.
+func GenerateSchedule(db *gorm.DB, generated []GeneratedMatch, options GenerateOptions) (SchedulePreview, error) {
+	first := 1
+	if options.Append {
+		var last int
+		if err := db.Model(&models.QualsMatch{}).Select("COALESCE(MAX(id), 0)").Scan(&last).Error; err != nil {
+			return SchedulePreview{}, err
+		}
+		first = last + 1
+	}
+
+	matches := make([]ImportedMatch, len(generated))
+	for i, match := range generated {
+		matches[i] = ImportedMatch{
+			Match:         first + i,
+			Red:           PlayerRef(strconv.Itoa(match.Red)),
+			Blue:          PlayerRef(strconv.Itoa(match.Blue)),
+			RedSurrogate:  match.RedSurrogate,
+			BlueSurrogate: match.BlueSurrogate,
+		}
+	}
+	preview, err := previewMatches(db, matches, first)
 	if err != nil {
-		fmt.Println("Error reading match schedule:", err)
-		return matches
+		return preview, err
 	}
 
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
+	if !options.Append && !options.Force {
+		played, err := countPlayed(db)
+		if err != nil {
+			return SchedulePreview{}, err
+		}
+		if played > 0 {
+			preview.conflict(0, "%d matches have already been played; append the new rounds or force a replacement", played)
 		}
+	}
 
-		fields := strings.Fields(line)
-		if len(fields) < 8 {
-			continue
+	schedule := preview.Matches
+	if options.Append {
+		existing, err := CurrentSchedule(db)
+		if err != nil {
+			return SchedulePreview{}, err
 		}
+		schedule = append(existing, schedule...)
+	}
+	preview.Report = AnalyzeSchedule(schedule)
 
-		// First match: 2nd and 4th numbers (index 1 and 3)
-		mmid1, err1 := strconv.Atoi(fields[1])
-		mmid2, err2 := strconv.Atoi(fields[3])
+	if options.Preview || len(preview.Conflicts) > 0 {
+		return preview, nil
+	}
+	err = saveSchedule(db, preview.Matches, !options.Append, options.Force)
+	var played MatchesPlayedError
+	if errors.As(err, &played) {
+		preview.conflict(0, "%d matches have already been played; append the new rounds or force a replacement", played.Played)
+		return preview, nil
+	}
+	return preview, err
+}
 
-		var userA, userB models.User
-		foundA := db.Where("mm_id = ?", mmid1).First(&userA).Error == nil
-		foundB := db.Where("mm_id = ?", mmid2).First(&userB).Error == nil
+// ScheduleDraft is a MatchMaker draw that was previewed. Committing the
+// draft saves exactly the matches the admin checked, rather than a new draw.
+type ScheduleDraft struct {
+	Generated []GeneratedMatch
+	Output    []byte // MatchMaker's output, saved to match_schedule.txt
+	Append    bool
+	created   time.Time
+}
 
-		match1 := map[string]interface{}{
-			"match": len(matches) + 1,
-		}
-		if err1 == nil && err2 == nil && foundA && foundB {
-			match1["team1"] = map[string]interface{}{
-				"mmid":              userA.MMID,
-				"username":          userA.Username,
-				"prefered_username": userA.PreferedUsername,
-			}
-			match1["team2"] = map[string]interface{}{
-				"mmid":              userB.MMID,
-				"username":          userB.Username,
-				"prefered_username": userB.PreferedUsername,
-			}
-		} else {
-			match1["error"] = fmt.Sprintf("MMID %d or %d not found", mmid1, mmid2)
-		}
-		matches = append(matches, match1)
+// Drafts are kept in memory by token until they're committed or expire
+var (
+	draftsMu sync.Mutex
+	drafts   = map[string]ScheduleDraft{}
+)
 
-		// Second match: 6th and 8th numbers (index 5 and 7)
-		mmid3, err3 := strconv.Atoi(fields[5])
-		mmid4, err4 := strconv.Atoi(fields[7])
+const draftLifetime = 30 * time.Minute
 
-		var userC, userD models.User
-		foundC := db.Where("mm_id = ?", mmid3).First(&userC).Error == nil
-		foundD := db.Where("mm_id = ?", mmid4).First(&userD).Error == nil
+// KeepDraft stores a previewed draw and returns the token to commit it with
+func KeepDraft(draft ScheduleDraft) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(random)
 
-		match2 := map[string]interface{}{
-			"match": len(matches) + 1,
-		}
-		if err3 == nil && err4 == nil && foundC && foundD {
-			match2["team1"] = map[string]interface{}{
-				"mmid":              userC.MMID,
-				"username":          userC.Username,
-				"prefered_username": userC.PreferedUsername,
-			}
-			match2["team2"] = map[string]interface{}{
-				"mmid":              userD.MMID,
-				"username":          userD.Username,
-				"prefered_username": userD.PreferedUsername,
-			}
-		} else {
-			match2["error"] = fmt.Sprintf("MMID %d or %d not found", mmid3, mmid4)
+	draftsMu.Lock()
+	defer draftsMu.Unlock()
+	for key, kept := range drafts {
+		if time.Since(kept.created) > draftLifetime {
+			delete(drafts, key)
 		}
-		matches = append(matches, match2)
 	}
+	draft.created = time.Now()
+	drafts[token] = draft
+	return token, nil
+}
 
-	return matches
+// FindDraft looks up a draft that hasn't expired
+func FindDraft(token string) (ScheduleDraft, bool) {
+	draftsMu.Lock()
+	defer draftsMu.Unlock()
+	draft, ok := drafts[token]
+	if !ok || time.Since(draft.created) > draftLifetime {
+		return ScheduleDraft{}, false
+	}
+	return draft, true
 }
 
-func ParseMatchScheduleFromDB() []map[string]interface{} {
-	db := config.InitDB()
+// DiscardDraft forgets a draft once it has been saved
+func DiscardDraft(token string) {
+	draftsMu.Lock()
+	defer draftsMu.Unlock()
+	delete(drafts, token)
+}
+
+func ParseMatchScheduleFromDB(db *gorm.DB) []map[string]interface{} {
 	var matches []map[string]interface{}
 
-	var qualsMatches []models.QualsMatch
-	if err := db.Find(&qualsMatches).Error; err != nil {
+	qualsMatches, err := repository.Matches(db)
+	if err != nil {
 		fmt.Println("Error reading matches from database:", err)
 		return matches
 	}
 
-	for i, match := range qualsMatches {
-		var redUser, blueUser models.User
-		foundRed := db.Where("mm_id = ?", match.RedPlayerID).First(&redUser).Error == nil
-		foundBlue := db.Where("mm_id = ?", match.BluePlayerID).First(&blueUser).Error == nil
+	for _, match := range qualsMatches {
+		redUser, blueUser := match.Red, match.Blue
+		foundRed := redUser.MMID != 0
+		foundBlue := blueUser.MMID != 0
 
 		matchData := map[string]interface{}{
-			"match": i + 1,
+			"match": match.ID,
 		}
 
 		if foundRed && foundBlue {
@@ -136,11 +222,13 @@ func ParseMatchScheduleFromDB() []map[string]interface{} {
 				"mmid":              redUser.MMID,
 				"username":          redUser.Username,
 				"prefered_username": redUser.PreferedUsername,
+				"surrogate":         match.RedSurrogate,
 			}
 			matchData["team2"] = map[string]interface{}{
 				"mmid":              blueUser.MMID,
 				"username":          blueUser.Username,
 				"prefered_username": blueUser.PreferedUsername,
+				"surrogate":         match.BlueSurrogate,
 			}
 		} else {
 			matchData["error"] = fmt.Sprintf("MMID %d or %d not found", match.RedPlayerID, match.BluePlayerID)
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"log"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"gorm.io/gorm"
+)
+
+// Register every WebSocket message. Client messages carry their handler.
+func init() {
+	// Client to server
+	Protocol.Register(MessageSpec{
+		Type:        "statusbar_init",
+		Direction:   ClientToServer,
+		Description: "Requests the current overlay state as separate messages. Deprecated in favour of request_snapshot.",
+		Payload:     models.WebSocketEmptyPayload{},
+		Handler:     handleStatusBarInit,
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "request_available_teams",
+		Direction:   ClientToServer,
+		Description: "Requests the teams that have not been picked for an alliance yet.",
+		Payload:     models.WebSocketEmptyPayload{},
+		Handler:     handleRequestAvailableTeams,
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "request_snapshot",
+		Direction:   ClientToServer,
+		Description: "Requests the full show state as a single state_snapshot message.",
+		Payload:     models.WebSocketEmptyPayload{},
+		Handler:     handleRequestSnapshot,
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "resume",
+		Direction:   ClientToServer,
+		Description: "Replays the subscribed broadcasts after last_seq. If they are no longer buffered the server sends a state_snapshot instead.",
+		Payload:     models.WebSocketResumePayload{},
+		Handler:     handleResume,
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "subscribe",
+		Direction:   ClientToServer,
+		Description: "Adds topics to the connection's subscriptions. The server replies with a subscriptions message.",
+		Payload:     models.WebSocketSubscribePayload{},
+		Handler:     handleSubscribe,
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "unsubscribe",
+		Direction:   ClientToServer,
+		Description: "Removes topics from the connection's subscriptions. The server replies with a subscriptions message.",
+		Payload:     models.WebSocketSubscribePayload{},
+		Handler:     handleUnsubscribe,
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "team_selected",
+		Direction:   ClientToServer,
+		Role:        RoleOperator,
+		Description: "Announces that a team was picked during alliance selection.",
+		Payload:     models.WebSocketTeamSelectedPayload{},
+		Handler:     handleTeamSelected,
+	})
+
+	// Server to client
+	Protocol.Register(MessageSpec{
+		Type:        "active_match_update",
+		Direction:   ServerToClient,
+		Topic:       TopicMatch,
+		Description: "The match currently on the field.",
+		Payload:     models.WebSocketMatchPayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "match_saved",
+		Direction:   ServerToClient,
+		Topic:       TopicMatch,
+		Description: "Scores were committed for a match; show the end screen.",
+		Payload:     models.WebSocketMatchSavedPayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "leaderboard_update",
+		Direction:   ServerToClient,
+		Topic:       TopicLeaderboard,
+		Description: "The full leaderboard, sorted by rank.",
+		Payload:     models.WebSocketLeaderboardPayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "leaderboard_toggle",
+		Direction:   ServerToClient,
+		Topic:       TopicLeaderboard,
+		Description: "Shows or hides the leaderboard.",
+		Payload:     models.WebSocketLeaderboardTogglePayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "alliance_selection",
+		Direction:   ServerToClient,
+		Topic:       TopicAlliance,
+		Description: "The captain and pick for one alliance.",
+		Payload:     models.WebSocketAllianceSelectionPayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "alliance_selection_toggle",
+		Direction:   ServerToClient,
+		Topic:       TopicAlliance,
+		Description: "Shows or hides the alliance selection board.",
+		Payload:     models.WebSocketToggleAllianceSlectionPayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "available_teams_update",
+		Direction:   ServerToClient,
+		Topic:       TopicAlliance,
+		Description: "Teams that can still be picked, sorted by rank.",
+		Payload:     models.WebSocketAvailableTeamsPayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "team_selection_made",
+		Direction:   ServerToClient,
+		Topic:       TopicAlliance,
+		Description: "A team was picked and should be removed from the available list.",
+		Payload:     models.WebSocketTeamSelectionPayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "match_clock",
+		Direction:   ServerToClient,
+		Topic:       TopicTimer,
+		Description: "The field state and match clock. Sent on the match topic when the field state changes, and every second on the timer topic while a match runs. The every-second ticks have no seq and aren't replayed on resume.",
+		Payload:     models.WebSocketMatchClockPayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "queue_update",
+		Direction:   ServerToClient,
+		Topic:       TopicQueue,
+		Description: "The match being played now, the match on deck and the matches in queue behind it.",
+		Payload:     models.WebSocketQueuePayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "schedule_update",
+		Direction:   ServerToClient,
+		Topic:       TopicSchedule,
+		Description: "How far ahead or behind the event is running. Sent whenever a match is committed or the schedule is re-timed.",
+		Payload:     models.WebSocketScheduleUpdatePayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "state_snapshot",
+		Direction:   ServerToClient,
+		Description: "The complete show state. Its seq is the last broadcast it reflects; later broadcasts have higher seq values.",
+		Payload:     models.WebSocketStateSnapshotPayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "subscriptions",
+		Direction:   ServerToClient,
+		Description: "The topics the connection is subscribed to.",
+		Payload:     models.WebSocketSubscriptionsPayload{},
+	})
+	Protocol.Register(MessageSpec{
+		Type:        "error",
+		Direction:   ServerToClient,
+		Description: "A client message was rejected.",
+		Payload:     models.WebSocketErrorPayload{},
+	})
+}
+
+func handleStatusBarInit(client *Client, db *gorm.DB, payload interface{}) error {
+	// Send initial status bar data - use stored state if available
+	state := currentShowState()
+	var statusBarData models.WebSocketMatchPayload
+	if state.match != nil {
+		statusBarData = *state.match
+	} else {
+		statusBarData = models.WebSocketMatchPayload{
+			RedAlliance:  []string{""},
+			BlueAlliance: []string{""},
+			EventName:    state.eventName,
+			MatchLevel:   "",
+			MatchID:      0,
+		}
+	}
+
+	if client.Subscribed(TopicMatch) {
+		if err := client.Send(NewMessage("active_match_update", statusBarData)); err != nil {
+			return err
+		}
+		log.Println("Sent initial status bar data")
+	}
+
+	if client.Subscribed(TopicLeaderboard) {
+		sendLeaderboardState(client, state)
+	}
+	if client.Subscribed(TopicAlliance) {
+		sendAllianceState(client, state)
+	}
+	return nil
+}
+
+func sendLeaderboardState(client *Client, state showState) {
+	// Send current leaderboard state if available
+	if state.leaderboard != nil {
+		leaderboardResponse := NewMessage("leaderboard_update", models.WebSocketLeaderboardPayload{
+			Users: state.leaderboard,
+		})
+		if err := client.Send(leaderboardResponse); err != nil {
+			log.Printf("WebSocket send error for leaderboard: %v", err)
+		} else {
+			log.Println("Sent stored leaderboard data")
+		}
+	}
+
+	// Send current leaderboard visibility state
+	leaderboardToggle := NewMessage("leaderboard_toggle", models.WebSocketLeaderboardTogglePayload{
+		Show: state.leaderboardVisible,
+	})
+	if err := client.Send(leaderboardToggle); err != nil {
+		log.Printf("WebSocket send error for leaderboard toggle: %v", err)
+	} else {
+		log.Printf("Sent leaderboard visibility state: %v", state.leaderboardVisible)
+	}
+}
+
+func sendAllianceState(client *Client, state showState) {
+	// Send current alliance selection visibility state
+	allianceToggle := NewMessage("alliance_selection_toggle", models.WebSocketToggleAllianceSlectionPayload{
+		Show: state.allianceSelectionVisible,
+	})
+	if err := client.Send(allianceToggle); err != nil {
+		log.Printf("WebSocket send error for alliance toggle: %v", err)
+	} else {
+		log.Printf("Sent alliance selection visibility state: %v", state.allianceSelectionVisible)
+	}
+
+	// Send current alliance selections if any exist
+	if len(state.allianceSelections) > 0 {
+		for _, selection := range state.allianceSelections {
+			if selection.AllianceCaptain != "" || selection.AllianceSelection != "" {
+				allianceResponse := NewMessage("alliance_selection", models.WebSocketAllianceSelectionPayload{
+					AllianceNumber:    selection.AllianceNumber,
+					AllianceCaptain:   selection.AllianceCaptain,
+					AllianceSelection: selection.AllianceSelection,
+				})
+				if err := client.Send(allianceResponse); err != nil {
+					log.Printf("WebSocket send error for alliance selection: %v", err)
+				}
+			}
+		}
+		log.Println("Sent stored alliance selection data")
+	}
+}
+
+func handleRequestAvailableTeams(client *Client, db *gorm.DB, payload interface{}) error {
+	response := NewMessage("available_teams_update", models.WebSocketAvailableTeamsPayload{
+		Teams: GetAvailableTeams(db),
+	})
+	if err := client.Send(response); err != nil {
+		return err
+	}
+	log.Println("Sent available teams data")
+	return nil
+}
+
+func handleTeamSelected(client *Client, db *gorm.DB, payload interface{}) error {
+	selected := payload.(models.WebSocketTeamSelectedPayload)
+	BroadcastTeamSelection(selected.Username)
+	return nil
+}
+
+func handleRequestSnapshot(client *Client, db *gorm.DB, payload interface{}) error {
+	return Manager.SendSnapshot(client)
+}
+
+func handleResume(client *Client, db *gorm.DB, payload interface{}) error {
+	lastSeq := payload.(models.WebSocketResumePayload).LastSeq
+	resumed, err := Manager.Resume(client, lastSeq)
+	if err != nil {
+		return err
+	}
+	if !resumed {
+		log.Printf("Client resuming from seq %d is too far behind to replay, sending snapshot", lastSeq)
+		return Manager.SendSnapshot(client)
+	}
+	return nil
+}
+
+func handleSubscribe(client *Client, db *gorm.DB, payload interface{}) error {
+	topics, err := validateTopics(payload.(models.WebSocketSubscribePayload).Topics, "subscribe")
+	if err != nil {
+		return err
+	}
+	client.Subscribe(topics...)
+	return client.Send(NewMessage("subscriptions", models.WebSocketSubscriptionsPayload{Topics: client.Topics()}))
+}
+
+func handleUnsubscribe(client *Client, db *gorm.DB, payload interface{}) error {
+	topics, err := validateTopics(payload.(models.WebSocketSubscribePayload).Topics, "unsubscribe")
+	if err != nil {
+		return err
+	}
+	client.Unsubscribe(topics...)
+	return client.Send(NewMessage("subscriptions", models.WebSocketSubscriptionsPayload{Topics: client.Topics()}))
+}
+
+func validateTopics(topics []string, requestType string) ([]string, error) {
+	for _, topic := range topics {
+		if !ValidTopic(topic) {
+			return nil, &ProtocolError{Code: ErrCodeInvalidPayload, Message: "unknown topic " + topic, RequestType: requestType}
+		}
+	}
+	return topics, nil
+}
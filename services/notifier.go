@@ -0,0 +1,87 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Notifier posts announcements to players. The server uses a DiscordNotifier
+// when the bot can connect and a NoopNotifier otherwise, so it still runs at
+// a LAN event or in CI.
+type Notifier interface {
+	SendMessage(channelID string, content string) error
+	SendEmbed(channelID string, embed *discordgo.MessageEmbed) error
+}
+
+// DiscordNotifier posts through a connected bot session
+type DiscordNotifier struct {
+	session *discordgo.Session
+}
+
+func NewDiscordNotifier(session *discordgo.Session) *DiscordNotifier {
+	return &DiscordNotifier{session: session}
+}
+
+func (d *DiscordNotifier) SendMessage(channelID string, content string) error {
+	_, err := d.session.ChannelMessageSend(channelID, content)
+	return err
+}
+
+func (d *DiscordNotifier) SendEmbed(channelID string, embed *discordgo.MessageEmbed) error {
+	_, err := d.session.ChannelMessageSendEmbed(channelID, embed)
+	return err
+}
+
+// NoopNotifier drops every announcement
+type NoopNotifier struct{}
+
+func (NoopNotifier) SendMessage(channelID string, content string) error {
+	return nil
+}
+
+func (NoopNotifier) SendEmbed(channelID string, embed *discordgo.MessageEmbed) error {
+	return nil
+}
+
+// SentMessage is an announcement a RecordingNotifier received. Exactly one
+// of Content and Embed is set.
+type SentMessage struct {
+	ChannelID string
+	Content   string
+	Embed     *discordgo.MessageEmbed
+}
+
+// RecordingNotifier keeps every announcement so tests can check what would
+// have been posted
+type RecordingNotifier struct {
+	mutex sync.Mutex
+	sent  []SentMessage
+}
+
+func (r *RecordingNotifier) SendMessage(channelID string, content string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sent = append(r.sent, SentMessage{ChannelID: channelID, Content: content})
+	return nil
+}
+
+func (r *RecordingNotifier) SendEmbed(channelID string, embed *discordgo.MessageEmbed) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sent = append(r.sent, SentMessage{ChannelID: channelID, Embed: embed})
+	return nil
+}
+
+// Sent returns the announcements received so far, oldest first
+func (r *RecordingNotifier) Sent() []SentMessage {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]SentMessage(nil), r.sent...)
+}
+
+func (r *RecordingNotifier) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sent = nil
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"gorm.io/gorm"
+)
+
+// ProtocolVersion is bumped whenever a message is added, removed or changes shape
+const ProtocolVersion = 1
+
+type MessageDirection string
+
+const (
+	ServerToClient MessageDirection = "server_to_client"
+	ClientToServer MessageDirection = "client_to_server"
+)
+
+// Error codes sent back to clients in an "error" message
+const (
+	ErrCodeMalformed          = "malformed_message"
+	ErrCodeUnknownType        = "unknown_type"
+	ErrCodeWrongDirection     = "wrong_direction"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeInvalidPayload     = "invalid_payload"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeInternal           = "internal_error"
+)
+
+// MessageHandler handles a decoded client message. The payload is a value of
+// the Go type registered for the message.
+type MessageHandler func(client *Client, db *gorm.DB, payload interface{}) error
+
+// PayloadValidator is implemented by payload types that need more than a
+// successful JSON decode to be considered valid
+type PayloadValidator interface {
+	Validate() error
+}
+
+type MessageSpec struct {
+	Type        string
+	Version     int
+	Direction   MessageDirection
+	Description string
+	Topic       string      // Topic server messages are broadcast on
+	Role        Role        // Least privileged role allowed to send a client message
+	Payload     interface{} // Zero value of the payload type
+	Handler     MessageHandler
+}
+
+// ProtocolError is returned when an incoming message can't be accepted
+type ProtocolError struct {
+	Code        string
+	Message     string
+	RequestType string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Payload converts the error into the body of an "error" message
+func (e *ProtocolError) Payload() models.WebSocketErrorPayload {
+	return models.WebSocketErrorPayload{
+		Code:        e.Code,
+		Message:     e.Message,
+		RequestType: e.RequestType,
+	}
+}
+
+type ProtocolRegistry struct {
+	specs map[string]MessageSpec
+	order []string
+}
+
+func NewProtocolRegistry() *ProtocolRegistry {
+	return &ProtocolRegistry{specs: make(map[string]MessageSpec)}
+}
+
+// Protocol is the registry of every message the WebSocket feed understands
+var Protocol = NewProtocolRegistry()
+
+// Register adds a message type to the registry. Registering the same type
+// twice is a programming error.
+func (r *ProtocolRegistry) Register(spec MessageSpec) {
+	if _, exists := r.specs[spec.Type]; exists {
+		panic("websocket message type registered twice: " + spec.Type)
+	}
+	if spec.Version == 0 {
+		spec.Version = 1
+	}
+	if spec.Payload == nil {
+		spec.Payload = models.WebSocketEmptyPayload{}
+	}
+	if spec.Direction == ClientToServer && spec.Role == "" {
+		spec.Role = RoleReadOnly
+	}
+	r.specs[spec.Type] = spec
+	r.order = append(r.order, spec.Type)
+}
+
+func (r *ProtocolRegistry) Lookup(messageType string) (MessageSpec, bool) {
+	spec, ok := r.specs[messageType]
+	return spec, ok
+}
+
+// Decode parses and validates a raw client message
+func (r *ProtocolRegistry) Decode(data []byte) (MessageSpec, interface{}, error) {
+	var envelope models.WebSocketInboundMessage
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return MessageSpec{}, nil, &ProtocolError{Code: ErrCodeMalformed, Message: "message is not valid JSON: " + err.Error()}
+	}
+	if envelope.Type == "" {
+		return MessageSpec{}, nil, &ProtocolError{Code: ErrCodeMalformed, Message: "message type is required"}
+	}
+
+	spec, ok := r.Lookup(envelope.Type)
+	if !ok {
+		return MessageSpec{}, nil, &ProtocolError{Code: ErrCodeUnknownType, Message: "unknown message type", RequestType: envelope.Type}
+	}
+	if spec.Direction != ClientToServer {
+		return spec, nil, &ProtocolError{Code: ErrCodeWrongDirection, Message: "message type can only be sent by the server", RequestType: envelope.Type}
+	}
+	if envelope.Version > spec.Version {
+		return spec, nil, &ProtocolError{
+			Code:        ErrCodeUnsupportedVersion,
+			Message:     fmt.Sprintf("server supports version %d of this message, got %d", spec.Version, envelope.Version),
+			RequestType: envelope.Type,
+		}
+	}
+
+	payload := reflect.New(reflect.TypeOf(spec.Payload))
+	if len(envelope.Payload) > 0 && string(envelope.Payload) != "null" {
+		if err := json.Unmarshal(envelope.Payload, payload.Interface()); err != nil {
+			return spec, nil, &ProtocolError{Code: ErrCodeInvalidPayload, Message: err.Error(), RequestType: envelope.Type}
+		}
+	}
+
+	value := payload.Elem().Interface()
+	if validator, ok := value.(PayloadValidator); ok {
+		if err := validator.Validate(); err != nil {
+			return spec, nil, &ProtocolError{Code: ErrCodeInvalidPayload, Message: err.Error(), RequestType: envelope.Type}
+		}
+	}
+
+	return spec, value, nil
+}
+
+// NewMessage builds an outgoing message stamped with its registered version.
+// The payload must match the registered payload type.
+func (r *ProtocolRegistry) NewMessage(messageType string, payload interface{}) models.WebSocketMessage {
+	spec, ok := r.Lookup(messageType)
+	if !ok {
+		log.Printf("Sending unregistered WebSocket message type: %s", messageType)
+		return models.WebSocketMessage{Type: messageType, Payload: payload}
+	}
+	if reflect.TypeOf(payload) != reflect.TypeOf(spec.Payload) {
+		log.Printf("WebSocket message %s sent with payload %T, expected %T", messageType, payload, spec.Payload)
+	}
+	return models.WebSocketMessage{
+		Type:    messageType,
+		Version: spec.Version,
+		Payload: payload,
+	}
+}
+
+// NewMessage builds an outgoing message using the default registry
+func NewMessage(messageType string, payload interface{}) models.WebSocketMessage {
+	return Protocol.NewMessage(messageType, payload)
+}
+
+type MessageDescription struct {
+	Type        string                 `json:"type"`
+	Version     int                    `json:"version"`
+	Direction   MessageDirection       `json:"direction"`
+	Description string                 `json:"description"`
+	Topic       string                 `json:"topic,omitempty"`
+	Role        Role                   `json:"role,omitempty"`
+	Payload     map[string]interface{} `json:"payload"`
+}
+
+type ProtocolDescription struct {
+	ProtocolVersion int                  `json:"protocol_version"`
+	Envelope        map[string]string    `json:"envelope"`
+	Topics          []string             `json:"topics"`
+	Roles           []Role               `json:"roles"`
+	Messages        []MessageDescription `json:"messages"`
+}
+
+// Describe returns a machine-readable description of every registered message.
+// Payloads are described with JSON Schema.
+func (r *ProtocolRegistry) Describe() ProtocolDescription {
+	types := append([]string(nil), r.order...)
+	sort.Strings(types)
+
+	description := ProtocolDescription{
+		ProtocolVersion: ProtocolVersion,
+		Envelope: map[string]string{
+			"type":    "string, the message type",
+			"version": "integer, the message version; clients may omit it",
+			"payload": "object, described per message below",
+		},
+		Roles:  []Role{RoleReadOnly, RoleOperator, RoleAdmin},
+		Topics: append(append([]string{TopicAll}, namedTopics...), playerTopicPrefix+"<mmid>"),
+	}
+	for _, messageType := range types {
+		spec := r.specs[messageType]
+		description.Messages = append(description.Messages, MessageDescription{
+			Type:        spec.Type,
+			Version:     spec.Version,
+			Direction:   spec.Direction,
+			Description: spec.Description,
+			Topic:       spec.Topic,
+			Role:        spec.Role,
+			Payload:     jsonSchema(reflect.TypeOf(spec.Payload)),
+		})
+	}
+	return description
+}
+
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// Times marshal as RFC 3339 strings rather than as structs
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, omitEmpty, skip := jsonFieldName(field)
+			if skip {
+				continue
+			}
+			properties[name] = jsonSchema(field.Type)
+			if !omitEmpty {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func jsonFieldName(field reflect.StructField) (string, bool, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	omitEmpty := false
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, false
+}
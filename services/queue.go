@@ -0,0 +1,188 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// DefaultQueueDepth is how many upcoming matches are shown: one on deck and
+// the rest in queue
+const DefaultQueueDepth = 3
+
+// MatchQueue tracks the qualification match being played now and the ones
+// coming up after it
+type MatchQueue struct {
+	mutex    sync.Mutex
+	depth    int
+	current  int          // ID of the match being played, 0 before the first one
+	notified map[int]bool // Matches whose players were already told they are on deck
+
+	lastStatus atomic.Pointer[models.WebSocketQueuePayload]
+}
+
+func NewMatchQueue(depth int) *MatchQueue {
+	queue := &MatchQueue{depth: depth, notified: make(map[int]bool)}
+	queue.lastStatus.Store(&models.WebSocketQueuePayload{InQueue: []models.WebSocketQueueEntry{}})
+	return queue
+}
+
+var Queue = NewMatchQueue(DefaultQueueDepth)
+
+func (q *MatchQueue) Depth() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.depth
+}
+
+func (q *MatchQueue) SetDepth(db *gorm.DB, depth int) {
+	q.mutex.Lock()
+	if depth < 1 {
+		depth = 1
+	}
+	q.depth = depth
+	q.mutex.Unlock()
+	q.Broadcast(db)
+}
+
+// Activate makes matchID the match being played. It returns the match that is
+// now on deck if its players haven't been notified yet.
+func (q *MatchQueue) Activate(db *gorm.DB, matchID int) *models.QualsMatch {
+	q.mutex.Lock()
+	q.current = matchID
+	q.mutex.Unlock()
+	return q.update(db)
+}
+
+// Commit advances the queue if matchID is the match being played. It returns
+// the match that is now on deck if its players haven't been notified yet.
+func (q *MatchQueue) Commit(db *gorm.DB, matchID int) *models.QualsMatch {
+	q.mutex.Lock()
+	if matchID != q.current {
+		q.mutex.Unlock()
+		q.Broadcast(db)
+		return nil
+	}
+
+	var next models.QualsMatch
+	if err := db.Where("id > ?", matchID).Order("id").First(&next).Error; err == nil {
+		q.current = next.ID
+	} else {
+		q.current = 0
+	}
+	q.mutex.Unlock()
+	return q.update(db)
+}
+
+// Reset forgets the current match, e.g. after the schedule is regenerated
+func (q *MatchQueue) Reset(db *gorm.DB) {
+	q.mutex.Lock()
+	q.current = 0
+	q.notified = make(map[int]bool)
+	q.mutex.Unlock()
+	q.Broadcast(db)
+}
+
+func (q *MatchQueue) update(db *gorm.DB) *models.QualsMatch {
+	status := q.Broadcast(db)
+	if status.OnDeck == nil {
+		return nil
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.notified[status.OnDeck.MatchID] {
+		return nil
+	}
+
+	var match models.QualsMatch
+	if err := db.First(&match, status.OnDeck.MatchID).Error; err != nil {
+		return nil
+	}
+	q.notified[match.ID] = true
+	return &match
+}
+
+// Status loads the current queue from the database
+func (q *MatchQueue) Status(db *gorm.DB) models.WebSocketQueuePayload {
+	q.mutex.Lock()
+	current, depth := q.current, q.depth
+	q.mutex.Unlock()
+
+	status := models.WebSocketQueuePayload{InQueue: []models.WebSocketQueueEntry{}}
+
+	var matches []models.QualsMatch
+	query := db.Order("id").Limit(depth + 1)
+	if current > 0 {
+		query = query.Where("id >= ?", current)
+	}
+	if err := query.Find(&matches).Error; err != nil {
+		log.Printf("Error loading match queue: %v", err)
+		return status
+	}
+
+	// Look up every player in the queue at once
+	var mmids []int
+	for _, match := range matches {
+		mmids = append(mmids, match.RedPlayerID, match.BluePlayerID)
+	}
+	var users []models.User
+	if len(mmids) > 0 {
+		db.Where("mm_id IN ?", mmids).Find(&users)
+	}
+	usersByMMID := make(map[int]models.User, len(users))
+	for _, user := range users {
+		usersByMMID[user.MMID] = user
+	}
+
+	entries := make([]models.WebSocketQueueEntry, 0, len(matches))
+	for _, match := range matches {
+		red, blue := usersByMMID[match.RedPlayerID], usersByMMID[match.BluePlayerID]
+		entries = append(entries, models.WebSocketQueueEntry{
+			MatchLevel:    "Quals",
+			MatchID:       match.ID,
+			RedAlliance:   []string{DisplayName(red)},
+			BlueAlliance:  []string{DisplayName(blue)},
+			RedSurrogate:  match.RedSurrogate,
+			BlueSurrogate: match.BlueSurrogate,
+		})
+	}
+
+	if current > 0 && len(entries) > 0 && entries[0].MatchID == current {
+		status.Now = &entries[0]
+		entries = entries[1:]
+	}
+	if len(entries) > depth {
+		entries = entries[:depth]
+	}
+	if len(entries) > 0 {
+		status.OnDeck = &entries[0]
+		status.InQueue = entries[1:]
+	}
+	return status
+}
+
+// LastStatus returns the queue as of its most recent broadcast
+func (q *MatchQueue) LastStatus() models.WebSocketQueuePayload {
+	return *q.lastStatus.Load()
+}
+
+// Broadcast sends the current queue to queue subscribers
+func (q *MatchQueue) Broadcast(db *gorm.DB) models.WebSocketQueuePayload {
+	status := q.Status(db)
+	q.lastStatus.Store(&status)
+	Manager.Broadcast(NewMessage("queue_update", status))
+	return status
+}
+
+// DisplayName prefers the name a player chose at registration
+func DisplayName(user models.User) string {
+	if user.PreferedUsername != "" {
+		return user.PreferedUsername
+	}
+	return user.Username
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+	"github.com/Jake-Schuler/MoSim-Event-Manager/repository"
+)
+
+// RankingHistoryEntry is where one player stood in a snapshot
+type RankingHistoryEntry struct {
+	MMID    int    `json:"mmid"`
+	Name    string `json:"name"`
+	Rank    int    `json:"rank"`
+	TotalRP int    `json:"total_rp"`
+}
+
+// RankingHistoryPoint is the rankings right after a match was committed.
+// It lists the players whose rank or total RP changed; everyone else is
+// where an earlier point put them.
+type RankingHistoryPoint struct {
+	Snapshot  int                   `json:"snapshot"`
+	MatchID   int                   `json:"match_id"`
+	Corrected bool                  `json:"corrected"`
+	TakenAt   time.Time             `json:"taken_at"`
+	Rankings  []RankingHistoryEntry `json:"rankings"`
+}
+
+// RecordRankingSnapshot stores the rankings after a match was committed,
+// with a row for each player whose standing changed since their last one.
+// Correcting a match records a new snapshot rather than editing the old
+// one, so the history shows what was on the leaderboard at the time.
+func RecordRankingSnapshot(db *gorm.DB, matchID int, corrected bool) error {
+	leaderboard, err := GetLeaderboard(db)
+	if err != nil || len(leaderboard) == 0 {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		snapshot := models.RankingSnapshot{MatchID: matchID, Corrected: corrected, TakenAt: time.Now()}
+		if err := tx.Create(&snapshot).Error; err != nil {
+			return err
+		}
+
+		var latest []models.RankingChange
+		if err := tx.Raw(`
+SELECT ranking_changes.mm_id, ranking_changes.rank, ranking_changes.total_rp
+FROM ranking_changes
+JOIN (SELECT mm_id, MAX(snapshot_id) AS snapshot_id FROM ranking_changes GROUP BY mm_id) latest
+	ON latest.mm_id = ranking_changes.mm_id AND latest.snapshot_id = ranking_changes.snapshot_id`).Scan(&latest).Error; err != nil {
+			return err
+		}
+		standings := make(map[int]models.RankingChange, len(latest))
+		for _, change := range latest {
+			standings[change.MMID] = change
+		}
+
+		var changes []models.RankingChange
+		for _, user := range leaderboard {
+			previous, ok := standings[user.MMID]
+			if ok && previous.Rank == user.Rank && previous.TotalRP == user.TotalRP {
+				continue
+			}
+			changes = append(changes, models.RankingChange{
+				SnapshotID: snapshot.ID,
+				MMID:       user.MMID,
+				Rank:       user.Rank,
+				TotalRP:    user.TotalRP,
+			})
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(changes, 100).Error
+	})
+}
+
+// ClearRankingHistory deletes every snapshot, for when the schedule they
+// were taken from is replaced
+func ClearRankingHistory(db *gorm.DB) error {
+	if err := db.Where("1 = 1").Delete(&models.RankingChange{}).Error; err != nil {
+		return err
+	}
+	return db.Where("1 = 1").Delete(&models.RankingSnapshot{}).Error
+}
+
+// RankingHistory lists the snapshots in the order they were taken, each with
+// the players whose standing changed. With an MMID, each snapshot holds that
+// player's standing whether or not it changed, from their first one on.
+func RankingHistory(db *gorm.DB, mmid int) ([]RankingHistoryPoint, error) {
+	var snapshots []models.RankingSnapshot
+	if err := db.Order("id").Find(&snapshots).Error; err != nil {
+		return nil, err
+	}
+	query := db.Order("snapshot_id").Order("rank")
+	if mmid != 0 {
+		query = query.Where("mm_id = ?", mmid)
+	}
+	var changes []models.RankingChange
+	if err := query.Find(&changes).Error; err != nil {
+		return nil, err
+	}
+	players, err := repository.PlayersByMMID(db)
+	if err != nil {
+		return nil, err
+	}
+
+	bySnapshot := map[int][]models.RankingChange{}
+	for _, change := range changes {
+		bySnapshot[change.SnapshotID] = append(bySnapshot[change.SnapshotID], change)
+	}
+
+	history := make([]RankingHistoryPoint, 0, len(snapshots))
+	var carried *RankingHistoryEntry
+	for _, snapshot := range snapshots {
+		point := RankingHistoryPoint{
+			Snapshot:  snapshot.ID,
+			MatchID:   snapshot.MatchID,
+			Corrected: snapshot.Corrected,
+			TakenAt:   snapshot.TakenAt,
+			Rankings:  []RankingHistoryEntry{},
+		}
+		for _, change := range bySnapshot[snapshot.ID] {
+			entry := RankingHistoryEntry{
+				MMID:    change.MMID,
+				Name:    DisplayName(players[change.MMID]),
+				Rank:    change.Rank,
+				TotalRP: change.TotalRP,
+			}
+			point.Rankings = append(point.Rankings, entry)
+			carried = &entry
+		}
+		if mmid != 0 && len(point.Rankings) == 0 && carried != nil {
+			point.Rankings = append(point.Rankings, *carried)
+		}
+		history = append(history, point)
+	}
+	return history, nil
+}
+
+// ExportRankingHistory lists every change of rank or total RP
+func ExportRankingHistory(db *gorm.DB) (ExportTable, error) {
+	history, err := RankingHistory(db, 0)
+	if err != nil {
+		return ExportTable{}, err
+	}
+
+	table := ExportTable{Columns: []string{
+		"snapshot", "match", "corrected", "taken_at", "rank", "mmid", "name", "total_rp",
+	}}
+	for _, point := range history {
+		for _, entry := range point.Rankings {
+			table.Rows = append(table.Rows, []interface{}{
+				point.Snapshot, point.MatchID, point.Corrected, point.TakenAt,
+				entry.Rank, entry.MMID, entry.Name, entry.TotalRP,
+			})
+		}
+	}
+	return table, nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Role is the level of trust a WebSocket connection was opened with
+type Role string
+
+const (
+	// RoleReadOnly can only request state; the default for anonymous viewers
+	RoleReadOnly Role = "read_only"
+	// RoleOperator connected with an API token from WS_API_TOKENS
+	RoleOperator Role = "operator"
+	// RoleAdmin connected through the authenticated admin routes
+	RoleAdmin Role = "admin"
+)
+
+func (r Role) level() int {
+	switch r {
+	case RoleAdmin:
+		return 2
+	case RoleOperator:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Allows reports whether the role meets the required role
+func (r Role) Allows(required Role) bool {
+	return r.level() >= required.level()
+}
+
+// RoleForToken returns the role granted by an API token
+func RoleForToken(token string) (Role, bool) {
+	if token == "" {
+		return RoleReadOnly, false
+	}
+	for _, allowed := range splitEnvList("WS_API_TOKENS") {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+			return RoleOperator, true
+		}
+	}
+	return RoleReadOnly, false
+}
+
+// CheckOrigin accepts same-host browsers, non-browser clients that send no
+// Origin, and origins listed in WS_ALLOWED_ORIGINS ("*" allows any origin)
+func CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if parsed, err := url.Parse(origin); err == nil && strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range splitEnvList("WS_ALLOWED_ORIGINS") {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	log.Printf("WebSocket upgrade rejected for origin: %s", origin)
+	return false
+}
+
+func splitEnvList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+func GetScheduleBlocks(db *gorm.DB) ([]models.ScheduleBlock, error) {
+	var blocks []models.ScheduleBlock
+	if err := db.Order("start").Find(&blocks).Error; err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+func GetScheduleBreaks(db *gorm.DB) ([]models.ScheduleBreak, error) {
+	var breaks []models.ScheduleBreak
+	if err := db.Order("after_match_id").Find(&breaks).Error; err != nil {
+		return nil, err
+	}
+	return breaks, nil
+}
+
+// AddScheduleBlock saves a new block and re-times the schedule
+func AddScheduleBlock(db *gorm.DB, block models.ScheduleBlock) error {
+	if block.Start.IsZero() {
+		return errors.New("block needs a start time")
+	}
+	if block.CycleSeconds <= 0 {
+		return errors.New("block cycle time must be positive")
+	}
+	if block.MatchCount < 0 {
+		return errors.New("block match count can't be negative")
+	}
+	if err := db.Create(&block).Error; err != nil {
+		return err
+	}
+	return ApplySchedule(db)
+}
+
+func DeleteScheduleBlock(db *gorm.DB, id int) error {
+	if err := db.Delete(&models.ScheduleBlock{}, id).Error; err != nil {
+		return err
+	}
+	return ApplySchedule(db)
+}
+
+// InsertBreak pauses the schedule after a match, pushing every later match
+// back by the break's length
+func InsertBreak(db *gorm.DB, afterMatchID int, duration time.Duration, label string) error {
+	if duration <= 0 {
+		return errors.New("break length must be positive")
+	}
+	if err := db.First(&models.QualsMatch{}, afterMatchID).Error; err != nil {
+		return errors.New("match not found")
+	}
+
+	scheduleBreak := models.ScheduleBreak{
+		AfterMatchID:    afterMatchID,
+		DurationSeconds: int(duration / time.Second),
+		Label:           label,
+	}
+	if err := db.Create(&scheduleBreak).Error; err != nil {
+		return err
+	}
+	return ApplySchedule(db)
+}
+
+func DeleteBreak(db *gorm.DB, id int) error {
+	if err := db.Delete(&models.ScheduleBreak{}, id).Error; err != nil {
+		return err
+	}
+	return ApplySchedule(db)
+}
+
+// ClearBreaks forgets every unplanned break, e.g. after the schedule is
+// regenerated and the matches they followed are gone
+func ClearBreaks(db *gorm.DB) error {
+	return db.Where("1 = 1").Delete(&models.ScheduleBreak{}).Error
+}
+
+// ApplySchedule stores a scheduled start for every qualification match from
+// the time blocks, then shifts matches after each unplanned break. Without
+// any blocks the scheduled starts are cleared.
+func ApplySchedule(db *gorm.DB) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		blocks, err := GetScheduleBlocks(tx)
+		if err != nil {
+			return err
+		}
+		breaks, err := GetScheduleBreaks(tx)
+		if err != nil {
+			return err
+		}
+		var matches []models.QualsMatch
+		if err := tx.Order("id").Find(&matches).Error; err != nil {
+			return err
+		}
+
+		blockOf := assignBlocks(blocks, len(matches))
+		position := 0 // Position of the match within its block
+		for i, match := range matches {
+			var start *time.Time
+			if blockOf[i] >= 0 {
+				if i > 0 && blockOf[i] != blockOf[i-1] {
+					position = 0
+				}
+				block := blocks[blockOf[i]]
+				scheduled := block.Start.
+					Add(time.Duration(position*block.CycleSeconds) * time.Second).
+					Add(breakShift(breaks, match.ID))
+				start = &scheduled
+				position++
+			}
+			if err := tx.Model(&match).Update("scheduled_start", start).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	BroadcastScheduleUpdate(db)
+	return nil
+}
+
+// assignBlocks returns the index of the block each match is played in, or -1
+// for every match when there are no blocks. The last block takes whatever
+// matches are left over.
+func assignBlocks(blocks []models.ScheduleBlock, matchCount int) []int {
+	blockOf := make([]int, matchCount)
+	block, used := 0, 0
+	for i := range blockOf {
+		if len(blocks) == 0 {
+			blockOf[i] = -1
+			continue
+		}
+		for block < len(blocks)-1 && blocks[block].MatchCount > 0 && used >= blocks[block].MatchCount {
+			block++
+			used = 0
+		}
+		blockOf[i] = block
+		used++
+	}
+	return blockOf
+}
+
+// breakShift is how far the unplanned breaks before a match push it back
+func breakShift(breaks []models.ScheduleBreak, matchID int) time.Duration {
+	var shift time.Duration
+	for _, scheduleBreak := range breaks {
+		if scheduleBreak.AfterMatchID < matchID {
+			shift += time.Duration(scheduleBreak.DurationSeconds) * time.Second
+		}
+	}
+	return shift
+}
+
+// BlockStarts indexes blocks by the ID of their first match for templates
+func BlockStarts(db *gorm.DB) map[int]models.ScheduleBlock {
+	starts := make(map[int]models.ScheduleBlock)
+	blocks, err := GetScheduleBlocks(db)
+	if err != nil || len(blocks) == 0 {
+		return starts
+	}
+	var ids []int
+	if err := db.Model(&models.QualsMatch{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return starts
+	}
+
+	blockOf := assignBlocks(blocks, len(ids))
+	for i, id := range ids {
+		if i == 0 || blockOf[i] != blockOf[i-1] {
+			starts[id] = blocks[blockOf[i]]
+		}
+	}
+	return starts
+}
+
+// BreaksAfter indexes unplanned breaks by the match they follow for templates
+func BreaksAfter(db *gorm.DB) map[int][]models.ScheduleBreak {
+	after := make(map[int][]models.ScheduleBreak)
+	breaks, err := GetScheduleBreaks(db)
+	if err != nil {
+		return after
+	}
+	for _, scheduleBreak := range breaks {
+		after[scheduleBreak.AfterMatchID] = append(after[scheduleBreak.AfterMatchID], scheduleBreak)
+	}
+	return after
+}
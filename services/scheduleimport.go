@@ -0,0 +1,342 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// PlayerRef names a player in an imported schedule by MMID, Discord ID or
+// name. JSON schedules may give it as a number or a string.
+type PlayerRef string
+
+func (p *PlayerRef) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*p = PlayerRef(text)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return errors.New("player must be a number or a string")
+	}
+	*p = PlayerRef(number.String())
+	return nil
+}
+
+// ImportedMatch is one row of an imported schedule
+type ImportedMatch struct {
+	Match         int       `json:"match"`
+	Red           PlayerRef `json:"red"`
+	Blue          PlayerRef `json:"blue"`
+	RedSurrogate  bool      `json:"red_surrogate"`
+	BlueSurrogate bool      `json:"blue_surrogate"`
+}
+
+// ScheduleConflict is a problem that stops a schedule from being imported.
+// Match is 0 for problems with the schedule as a whole.
+type ScheduleConflict struct {
+	Match   int    `json:"match"`
+	Message string `json:"message"`
+}
+
+// MatchPreview is an imported or generated match with its players resolved
+type MatchPreview struct {
+	Match         int    `json:"match"`
+	RedMMID       int    `json:"red_mmid"`
+	RedName       string `json:"red_name"`
+	BlueMMID      int    `json:"blue_mmid"`
+	BlueName      string `json:"blue_name"`
+	RedSurrogate  bool   `json:"red_surrogate"`
+	BlueSurrogate bool   `json:"blue_surrogate"`
+}
+
+type SchedulePreview struct {
+	Matches   []MatchPreview     `json:"matches"`
+	Conflicts []ScheduleConflict `json:"conflicts"`
+	Report    ScheduleReport     `json:"report"` // Of the whole schedule as it would be saved
+}
+
+// ParseScheduleImport reads a schedule as JSON (an array of objects with
+// match, red and blue) or as CSV with a header row naming the same columns.
+// The red_mmid and blue_mmid columns of the schedule export work too, and
+// red_surrogate and blue_surrogate optionally mark surrogate appearances.
+func ParseScheduleImport(data []byte, format string) ([]ImportedMatch, error) {
+	switch format {
+	case "json":
+		var matches []ImportedMatch
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&matches); err != nil {
+			return nil, fmt.Errorf("invalid JSON schedule: %v", err)
+		}
+		return matches, nil
+	case "csv":
+		return parseScheduleCSV(data)
+	default:
+		return nil, errors.New("schedule must be CSV or JSON")
+	}
+}
+
+func parseScheduleCSV(data []byte) ([]ImportedMatch, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("CSV schedule needs a header row")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		name = strings.TrimSuffix(name, "_mmid")
+		if _, seen := columns[name]; !seen {
+			columns[name] = i
+		}
+	}
+	for _, required := range []string{"match", "red", "blue"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV schedule is missing the %s column", required)
+		}
+	}
+
+	var matches []ImportedMatch
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV schedule: %v", err)
+		}
+		field := func(name string) string {
+			if i := columns[name]; i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		if field("match") == "" && field("red") == "" && field("blue") == "" {
+			continue
+		}
+		number, err := strconv.Atoi(field("match"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid match number %q", line, field("match"))
+		}
+		match := ImportedMatch{
+			Match: number,
+			Red:   PlayerRef(field("red")),
+			Blue:  PlayerRef(field("blue")),
+		}
+		for _, surrogate := range []struct {
+			column string
+			flag   *bool
+		}{{"red_surrogate", &match.RedSurrogate}, {"blue_surrogate", &match.BlueSurrogate}} {
+			if _, ok := columns[surrogate.column]; !ok || field(surrogate.column) == "" {
+				continue
+			}
+			if *surrogate.flag, err = strconv.ParseBool(field(surrogate.column)); err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s %q", line, surrogate.column, field(surrogate.column))
+			}
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// resolvePlayer finds the player a reference names. Numbers are tried as an
+// MMID and then as a Discord ID; anything else is matched against names,
+// ignoring case.
+func resolvePlayer(users []models.User, ref PlayerRef) (models.User, error) {
+	text := strings.TrimSpace(string(ref))
+	if text == "" {
+		return models.User{}, errors.New("player is missing")
+	}
+
+	if number, err := strconv.Atoi(text); err == nil {
+		for _, user := range users {
+			if user.MMID == number {
+				return user, nil
+			}
+		}
+		for _, user := range users {
+			if user.ID == number {
+				return user, nil
+			}
+		}
+	}
+
+	var found []models.User
+	for _, user := range users {
+		if strings.EqualFold(user.PreferedUsername, text) || strings.EqualFold(user.Username, text) {
+			found = append(found, user)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return models.User{}, fmt.Errorf("no player %q", text)
+	case 1:
+		return found[0], nil
+	default:
+		return models.User{}, fmt.Errorf("%q matches more than one player", text)
+	}
+}
+
+// PreviewScheduleImport resolves every player in an imported schedule and
+// lists anything that stops it from being imported: unknown players, a
+// player against themselves, match numbers that repeat or don't run from 1
+// without gaps, and results that replacing the schedule would throw away.
+func PreviewScheduleImport(db *gorm.DB, matches []ImportedMatch) (SchedulePreview, error) {
+	preview, err := previewMatches(db, matches, 1)
+	if err != nil {
+		return preview, err
+	}
+
+	played, err := countPlayed(db)
+	if err != nil {
+		return SchedulePreview{}, err
+	}
+	if played > 0 {
+		preview.conflict(0, "%d matches have already been played and would be lost", played)
+	}
+	preview.Report = AnalyzeSchedule(preview.Matches)
+	return preview, nil
+}
+
+// ImportSchedule replaces the qualification schedule with an imported one in
+// a single transaction. Nothing changes if the preview has conflicts.
+func ImportSchedule(db *gorm.DB, matches []ImportedMatch) (SchedulePreview, error) {
+	preview, err := PreviewScheduleImport(db, matches)
+	if err != nil || len(preview.Conflicts) > 0 {
+		return preview, err
+	}
+	err = saveSchedule(db, preview.Matches, true, false)
+	var played MatchesPlayedError
+	if errors.As(err, &played) {
+		preview.conflict(0, "%d matches have already been played and would be lost", played.Played)
+		return preview, nil
+	}
+	return preview, err
+}
+
+func (p *SchedulePreview) conflict(match int, format string, args ...interface{}) {
+	p.Conflicts = append(p.Conflicts, ScheduleConflict{Match: match, Message: fmt.Sprintf(format, args...)})
+}
+
+// previewMatches resolves every player and checks that match numbers run on
+// from first without repeats or gaps
+func previewMatches(db *gorm.DB, matches []ImportedMatch, first int) (SchedulePreview, error) {
+	var users []models.User
+	if err := db.Find(&users).Error; err != nil {
+		return SchedulePreview{}, err
+	}
+
+	result := SchedulePreview{Matches: []MatchPreview{}, Conflicts: []ScheduleConflict{}}
+	if len(matches) == 0 {
+		result.conflict(0, "schedule has no matches")
+	}
+
+	sorted := append([]ImportedMatch(nil), matches...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Match < sorted[j].Match })
+	expected := first
+	for i, match := range sorted {
+		if i > 0 && match.Match == sorted[i-1].Match {
+			result.conflict(match.Match, "match %d is listed more than once", match.Match)
+		} else {
+			if match.Match != expected {
+				result.conflict(match.Match, "expected match %d here, match numbers must run from %d without gaps", expected, first)
+			}
+			expected = match.Match + 1
+		}
+
+		preview := MatchPreview{Match: match.Match, RedSurrogate: match.RedSurrogate, BlueSurrogate: match.BlueSurrogate}
+		red, redErr := resolvePlayer(users, match.Red)
+		if redErr != nil {
+			result.conflict(match.Match, "red: %v", redErr)
+		} else {
+			preview.RedMMID, preview.RedName = red.MMID, DisplayName(red)
+		}
+		blue, blueErr := resolvePlayer(users, match.Blue)
+		if blueErr != nil {
+			result.conflict(match.Match, "blue: %v", blueErr)
+		} else {
+			preview.BlueMMID, preview.BlueName = blue.MMID, DisplayName(blue)
+		}
+		if redErr == nil && blueErr == nil && red.MMID == blue.MMID {
+			result.conflict(match.Match, "%s can't play against themselves", DisplayName(red))
+		}
+		result.Matches = append(result.Matches, preview)
+	}
+	return result, nil
+}
+
+func countPlayed(db *gorm.DB) (int64, error) {
+	var played int64
+	err := db.Model(&models.QualsMatch{}).Where("committed_at IS NOT NULL").Count(&played).Error
+	return played, err
+}
+
+// MatchesPlayedError stops a schedule from replacing one that already has
+// results
+type MatchesPlayedError struct {
+	Played int64
+}
+
+func (e MatchesPlayedError) Error() string {
+	return fmt.Sprintf("%d matches have already been played", e.Played)
+}
+
+// saveSchedule writes matches in a single transaction. Replacing deletes
+// every existing match first, along with the breaks that followed them and
+// the ranking history taken from their results. Unless forced, replacing is
+// refused with a MatchesPlayedError once a match has been played; the check
+// is made in the same transaction so a match committed meanwhile isn't lost.
+func saveSchedule(db *gorm.DB, matches []MatchPreview, replace bool, force bool) error {
+	// Replaced matches take their results with them
+	defer InvalidateRankings()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if replace && !force {
+			played, err := countPlayed(tx)
+			if err != nil {
+				return err
+			}
+			if played > 0 {
+				return MatchesPlayedError{Played: played}
+			}
+		}
+		if replace {
+			if err := tx.Where("1 = 1").Delete(&models.QualsMatch{}).Error; err != nil {
+				return err
+			}
+			if err := ClearBreaks(tx); err != nil {
+				return err
+			}
+			if err := ClearRankingHistory(tx); err != nil {
+				return err
+			}
+		}
+		for _, match := range matches {
+			if err := tx.Create(&models.QualsMatch{
+				ID:            match.Match,
+				RedPlayerID:   match.RedMMID,
+				BluePlayerID:  match.BlueMMID,
+				RedSurrogate:  match.RedSurrogate,
+				BlueSurrogate: match.BlueSurrogate,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// RepeatOpponent is an opponent a player meets more than once
+type RepeatOpponent struct {
+	MMID  int    `json:"mmid"`
+	Name  string `json:"name"`
+	Times int    `json:"times"`
+}
+
+// PlayerScheduleStats is how fair the schedule is to one player. Matches are
+// one against one, so there are no partners to repeat.
+type PlayerScheduleStats struct {
+	MMID            int              `json:"mmid"`
+	Name            string           `json:"name"`
+	Matches         int              `json:"matches"`
+	Red             int              `json:"red"`
+	Blue            int              `json:"blue"`
+	Surrogates      int              `json:"surrogates"`     // Extra matches that don't count towards their ranking
+	MinTurnaround   int              `json:"min_turnaround"` // Fewest matches played between two of theirs, 0 being back to back; -1 with fewer than two matches
+	AvgTurnaround   float64          `json:"avg_turnaround"` // Matches played between two of theirs, on average
+	RepeatOpponents []RepeatOpponent `json:"repeat_opponents"`
+	Warnings        []string         `json:"warnings"`
+}
+
+type ScheduleReport struct {
+	Players  []PlayerScheduleStats `json:"players"`
+	Warnings []string              `json:"warnings"` // Every player's warnings, prefixed with their name
+}
+
+// AnalyzeSchedule works out each player's match count, red/blue balance,
+// turnaround and repeat opponents, and warns about players who play more or
+// less than usual, are unbalanced, play back to back, get much less rest
+// than average, or meet an opponent more often than the schedule needs.
+func AnalyzeSchedule(matches []MatchPreview) ScheduleReport {
+	sorted := append([]MatchPreview(nil), matches...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Match < sorted[j].Match })
+
+	stats := map[int]*PlayerScheduleStats{}
+	played := map[int][]int{} // Index in the schedule of each of a player's matches
+	opponents := map[int]map[int]int{}
+	names := map[int]string{}
+	player := func(mmid int, name string) *PlayerScheduleStats {
+		if stats[mmid] == nil {
+			stats[mmid] = &PlayerScheduleStats{MMID: mmid, Name: name, MinTurnaround: -1, RepeatOpponents: []RepeatOpponent{}, Warnings: []string{}}
+			opponents[mmid] = map[int]int{}
+			names[mmid] = name
+		}
+		return stats[mmid]
+	}
+
+	for i, match := range sorted {
+		// Players that couldn't be resolved are left out but still take a slot
+		if match.RedMMID == 0 || match.BlueMMID == 0 {
+			continue
+		}
+		red := player(match.RedMMID, match.RedName)
+		blue := player(match.BlueMMID, match.BlueName)
+		red.Matches++
+		red.Red++
+		blue.Matches++
+		blue.Blue++
+		if match.RedSurrogate {
+			red.Surrogates++
+		}
+		if match.BlueSurrogate {
+			blue.Surrogates++
+		}
+		played[red.MMID] = append(played[red.MMID], i)
+		played[blue.MMID] = append(played[blue.MMID], i)
+		opponents[red.MMID][blue.MMID]++
+		opponents[blue.MMID][red.MMID]++
+	}
+
+	report := ScheduleReport{Players: []PlayerScheduleStats{}, Warnings: []string{}}
+	if len(stats) == 0 {
+		return report
+	}
+
+	// Most players play the usual number of matches; anyone else stands out.
+	// Surrogate matches are expected extras, so they aren't counted.
+	counts := map[int]int{}
+	for _, stat := range stats {
+		counts[stat.Matches-stat.Surrogates]++
+	}
+	usual := 0
+	for count, players := range counts {
+		if players > counts[usual] || (players == counts[usual] && count > usual) {
+			usual = count
+		}
+	}
+	// Meeting someone more often than this can't be blamed on the player count
+	fairRepeats := 1
+	if len(stats) > 1 {
+		fairRepeats = (usual + len(stats) - 2) / (len(stats) - 1)
+	}
+
+	var turnaroundTotal float64
+	var turnaroundPlayers int
+	for mmid, stat := range stats {
+		indexes := played[mmid]
+		if len(indexes) > 1 {
+			gaps := 0
+			for i := 1; i < len(indexes); i++ {
+				gap := indexes[i] - indexes[i-1] - 1
+				gaps += gap
+				if stat.MinTurnaround < 0 || gap < stat.MinTurnaround {
+					stat.MinTurnaround = gap
+				}
+			}
+			stat.AvgTurnaround = float64(gaps) / float64(len(indexes)-1)
+			turnaroundTotal += stat.AvgTurnaround
+			turnaroundPlayers++
+		}
+
+		for opponent, times := range opponents[mmid] {
+			if times > 1 {
+				stat.RepeatOpponents = append(stat.RepeatOpponents, RepeatOpponent{MMID: opponent, Name: names[opponent], Times: times})
+			}
+		}
+		sort.Slice(stat.RepeatOpponents, func(i, j int) bool { return stat.RepeatOpponents[i].MMID < stat.RepeatOpponents[j].MMID })
+	}
+
+	averageTurnaround := 0.0
+	if turnaroundPlayers > 0 {
+		averageTurnaround = turnaroundTotal / float64(turnaroundPlayers)
+	}
+
+	for _, stat := range stats {
+		if counted := stat.Matches - stat.Surrogates; counted != usual {
+			stat.Warnings = append(stat.Warnings, fmt.Sprintf("plays %d ranked matches, most play %d", counted, usual))
+		}
+		if diff := stat.Red - stat.Blue; diff > 1 || diff < -1 {
+			stat.Warnings = append(stat.Warnings, fmt.Sprintf("is red %d times and blue %d times", stat.Red, stat.Blue))
+		}
+		if stat.MinTurnaround == 0 {
+			stat.Warnings = append(stat.Warnings, "plays back to back")
+		}
+		if stat.MinTurnaround >= 0 && stat.AvgTurnaround < averageTurnaround/2 {
+			stat.Warnings = append(stat.Warnings, fmt.Sprintf("rests %.1f matches on average, under half the event average of %.1f", stat.AvgTurnaround, averageTurnaround))
+		}
+		for _, repeat := range stat.RepeatOpponents {
+			if repeat.Times > fairRepeats {
+				stat.Warnings = append(stat.Warnings, fmt.Sprintf("meets %s %d times", repeat.Name, repeat.Times))
+			}
+		}
+		report.Players = append(report.Players, *stat)
+	}
+
+	sort.Slice(report.Players, func(i, j int) bool { return report.Players[i].MMID < report.Players[j].MMID })
+	for _, stat := range report.Players {
+		for _, warning := range stat.Warnings {
+			report.Warnings = append(report.Warnings, stat.Name+" "+warning)
+		}
+	}
+	return report
+}
+
+// CurrentSchedule lists the saved qualification matches with player names
+func CurrentSchedule(db *gorm.DB) ([]MatchPreview, error) {
+	names, err := playerNames(db)
+	if err != nil {
+		return nil, err
+	}
+	var matches []models.QualsMatch
+	if err := db.Order("id").Find(&matches).Error; err != nil {
+		return nil, err
+	}
+
+	schedule := make([]MatchPreview, len(matches))
+	for i, match := range matches {
+		schedule[i] = MatchPreview{
+			Match:         match.ID,
+			RedMMID:       match.RedPlayerID,
+			RedName:       names[match.RedPlayerID],
+			BlueMMID:      match.BluePlayerID,
+			BlueName:      names[match.BluePlayerID],
+			RedSurrogate:  match.RedSurrogate,
+			BlueSurrogate: match.BlueSurrogate,
+		}
+	}
+	return schedule, nil
+}
+
+// ExportScheduleReport is the quality report of the saved schedule
+func ExportScheduleReport(db *gorm.DB) (ExportTable, error) {
+	schedule, err := CurrentSchedule(db)
+	if err != nil {
+		return ExportTable{}, err
+	}
+	report := AnalyzeSchedule(schedule)
+
+	table := ExportTable{Columns: []string{
+		"mmid", "name", "matches", "red", "blue", "surrogates", "min_turnaround", "avg_turnaround", "repeat_opponents", "warnings",
+	}}
+	for _, stat := range report.Players {
+		var repeats []string
+		for _, repeat := range stat.RepeatOpponents {
+			repeats = append(repeats, fmt.Sprintf("%s x%d", repeat.Name, repeat.Times))
+		}
+		table.Rows = append(table.Rows, []interface{}{
+			stat.MMID, stat.Name, stat.Matches, stat.Red, stat.Blue, stat.Surrogates,
+			stat.MinTurnaround, fmt.Sprintf("%.2f", stat.AvgTurnaround),
+			strings.Join(repeats, "; "), strings.Join(stat.Warnings, "; "),
+		})
+	}
+	return table, nil
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// BuildShowState collects everything an overlay needs to render into one
+// snapshot. seq is the last broadcast the snapshot reflects.
+func BuildShowState(seq uint64) models.WebSocketStateSnapshotPayload {
+	state := currentShowState()
+	snapshot := models.WebSocketStateSnapshotPayload{
+		Seq:                      seq,
+		EventName:                state.eventName,
+		ActiveMatch:              state.match,
+		Leaderboard:              state.leaderboard,
+		LeaderboardVisible:       state.leaderboardVisible,
+		AllianceSelectionVisible: state.allianceSelectionVisible,
+		AllianceSelections:       []models.WebSocketAllianceSelectionPayload{},
+		MatchClock:               Field.LastStatus(),
+		Queue:                    Queue.LastStatus(),
+	}
+	if snapshot.Leaderboard == nil {
+		snapshot.Leaderboard = []models.User{}
+	}
+
+	for _, selection := range state.allianceSelections {
+		if selection.AllianceCaptain == "" && selection.AllianceSelection == "" {
+			continue
+		}
+		snapshot.AllianceSelections = append(snapshot.AllianceSelections, models.WebSocketAllianceSelectionPayload{
+			AllianceNumber:    selection.AllianceNumber,
+			AllianceCaptain:   selection.AllianceCaptain,
+			AllianceSelection: selection.AllianceSelection,
+		})
+	}
+
+	return snapshot
+}
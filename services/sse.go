@@ -0,0 +1,91 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Comment lines are sent this often to keep proxies from closing idle streams
+const sseKeepAlivePeriod = 30 * time.Second
+
+// ServeEvents streams the feed as Server-Sent Events until the request ends.
+// Each event's id is its sequence number, so a reconnecting EventSource
+// resumes from Last-Event-ID; without one the stream starts with a snapshot.
+func ServeEvents(w http.ResponseWriter, r *http.Request, topics []string, lastSeq uint64) {
+	controller := http.NewResponseController(w)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		log.Printf("Event stream does not support flushing: %v", err)
+		return
+	}
+
+	// Server-Sent Events are one way, so the client never needs more than read-only
+	client := NewClient(nil, topics, RoleReadOnly)
+	Manager.AddConnection(client)
+	defer func() {
+		Manager.RemoveConnection(client)
+		client.Close()
+	}()
+	log.Println("Event stream established")
+
+	resumed := false
+	if lastSeq > 0 {
+		var err error
+		if resumed, err = Manager.Resume(client, lastSeq); err != nil {
+			return
+		}
+	}
+	if !resumed {
+		if err := Manager.SendSnapshot(client); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(sseKeepAlivePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-client.send:
+			controller.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := writeEvent(w, message); err != nil {
+				log.Printf("Event stream write error: %v", err)
+				return
+			}
+		case <-ticker.C:
+			controller.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				log.Printf("Event stream write error: %v", err)
+				return
+			}
+		case <-client.done:
+			return
+		case <-r.Context().Done():
+			log.Println("Event stream closed")
+			return
+		}
+
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, message outboundMessage) error {
+	if message.seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", message.seq); err != nil {
+			return err
+		}
+	}
+	// Encoded JSON never contains raw newlines, so one data line is enough
+	_, err := fmt.Fprintf(w, "data: %s\n\n", message.data)
+	return err
+}
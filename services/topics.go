@@ -0,0 +1,65 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Topics group broadcasts so clients only receive what they display
+const (
+	TopicAll         = "*"
+	TopicMatch       = "match"
+	TopicLeaderboard = "leaderboard"
+	TopicAlliance    = "alliance"
+	TopicBracket     = "bracket"
+	TopicTimer       = "timer"
+	TopicQueue       = "queue"
+	TopicSchedule    = "schedule"
+
+	playerTopicPrefix = "player:"
+)
+
+var namedTopics = []string{TopicMatch, TopicLeaderboard, TopicAlliance, TopicBracket, TopicTimer, TopicQueue, TopicSchedule}
+
+// PlayerTopic is the topic for messages about a single player, keyed by MMID
+func PlayerTopic(mmid int) string {
+	return playerTopicPrefix + strconv.Itoa(mmid)
+}
+
+func ValidTopic(topic string) bool {
+	if topic == TopicAll {
+		return true
+	}
+	for _, named := range namedTopics {
+		if topic == named {
+			return true
+		}
+	}
+	if id, ok := strings.CutPrefix(topic, playerTopicPrefix); ok {
+		mmid, err := strconv.Atoi(id)
+		return err == nil && mmid > 0
+	}
+	return false
+}
+
+// ParseTopics reads a comma separated topic list such as "match,player:4".
+// An empty list subscribes to everything.
+func ParseTopics(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return []string{TopicAll}, nil
+	}
+
+	var topics []string
+	for _, topic := range strings.Split(raw, ",") {
+		topic = strings.TrimSpace(topic)
+		if topic == "" {
+			continue
+		}
+		if !ValidTopic(topic) {
+			return nil, fmt.Errorf("unknown topic %q", topic)
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"errors"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// registrationMutex keeps two sign-ups from taking the same MMID
+var registrationMutex sync.Mutex
+
+// RegisterUser signs up a Discord user and hands out the next MMID. It
+// returns false with the existing user if they were already registered.
+func RegisterUser(db *gorm.DB, discordID int, username string, preferredName string) (models.User, bool, error) {
+	registrationMutex.Lock()
+	defer registrationMutex.Unlock()
+
+	var existing models.User
+	if err := db.First(&existing, discordID).Error; err == nil {
+		return existing, false, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.User{}, false, err
+	}
+
+	user := models.User{
+		ID:               discordID,
+		Username:         username,
+		PreferedUsername: preferredName,
+		MMID:             CurrentMMID,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		return models.User{}, false, err
+	}
+	CurrentMMID++
+	InvalidateRankings()
+	return user, true, nil
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+const (
+	webhookBatchSize = 10          // Deliveries sent at once
+	webhookIdlePoll  = time.Minute // Longest wait between looks for due deliveries
+)
+
+// WebhookDispatcher sends webhook deliveries as they fall due. The schedule
+// lives in the database, so retries that were pending when the server
+// stopped carry on when it starts again.
+type WebhookDispatcher struct {
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+var Webhooks = &WebhookDispatcher{wake: make(chan struct{}, 1)}
+
+// Start sends due deliveries in the background until Stop
+func (d *WebhookDispatcher) Start(db *gorm.DB) {
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	go d.run(db)
+}
+
+// Stop lets the attempts in flight finish, then stops sending. Deliveries
+// still due are sent after the next Start.
+func (d *WebhookDispatcher) Stop() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+	d.stop = nil
+}
+
+// Wake makes the dispatcher look for due deliveries straight away
+func (d *WebhookDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *WebhookDispatcher) run(db *gorm.DB) {
+	defer close(d.done)
+	for {
+		select {
+		case <-d.stop:
+			return
+		default:
+		}
+
+		timer := time.NewTimer(d.sendDue(db))
+		select {
+		case <-d.stop:
+			timer.Stop()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// sendDue sends every delivery that's due and returns how long until the
+// next one is
+func (d *WebhookDispatcher) sendDue(db *gorm.DB) time.Duration {
+	for {
+		var due []models.WebhookDelivery
+		if err := db.Where("next_attempt_at <= ?", time.Now().UTC()).Order("next_attempt_at").Order("id").Limit(webhookBatchSize).Find(&due).Error; err != nil {
+			log.Printf("Error fetching due webhook deliveries: %v", err)
+			return webhookIdlePoll
+		}
+		if len(due) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range due {
+			wg.Add(1)
+			go func(delivery models.WebhookDelivery) {
+				defer wg.Done()
+				attemptWebhook(db, delivery)
+			}(delivery)
+		}
+		wg.Wait()
+
+		select {
+		case <-d.stop:
+			return webhookIdlePoll
+		default:
+		}
+	}
+
+	var next []models.WebhookDelivery
+	if err := db.Where("next_attempt_at IS NOT NULL").Order("next_attempt_at").Limit(1).Find(&next).Error; err != nil {
+		log.Printf("Error fetching pending webhook deliveries: %v", err)
+		return webhookIdlePoll
+	}
+	if len(next) == 0 {
+		return webhookIdlePoll
+	}
+	wait := time.Until(*next[0].NextAttemptAt)
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > webhookIdlePoll {
+		wait = webhookIdlePoll
+	}
+	return wait
+}
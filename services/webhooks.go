@@ -0,0 +1,306 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
+)
+
+// Webhook events
+const (
+	WebhookUserRegistered    = "user.registered"
+	WebhookScheduleGenerated = "schedule.generated"
+	WebhookMatchActivated    = "match.activated"
+	WebhookScoreCommitted    = "score.committed"
+	WebhookRankingsChanged   = "rankings.changed"
+	WebhookAlliancePick      = "alliance.pick"
+	WebhookBracketAdvanced   = "bracket.advanced"
+)
+
+var WebhookEvents = []string{
+	WebhookUserRegistered,
+	WebhookScheduleGenerated,
+	WebhookMatchActivated,
+	WebhookScoreCommitted,
+	WebhookRankingsChanged,
+	WebhookAlliancePick,
+	WebhookBracketAdvanced,
+}
+
+// webhookRetryDelays is how long to wait before each retry of a failed
+// delivery. A delivery is given up on once they run out.
+var webhookRetryDelays = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute, 30 * time.Minute}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func GetWebhooks(db *gorm.DB) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	if err := db.Order("id").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// AddWebhook subscribes a URL to events, or to every event if none are
+// given. A secret is generated when left blank.
+func AddWebhook(db *gorm.DB, target string, secret string, events []string) (models.Webhook, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return models.Webhook{}, errors.New("webhook URL must be an http or https URL")
+	}
+	for _, event := range events {
+		if !isWebhookEvent(event) {
+			return models.Webhook{}, fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+	if secret == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return models.Webhook{}, err
+		}
+		secret = hex.EncodeToString(random)
+	}
+
+	webhook := models.Webhook{
+		URL:     target,
+		Secret:  secret,
+		Events:  strings.Join(events, ","),
+		Enabled: true,
+	}
+	if err := db.Create(&webhook).Error; err != nil {
+		return models.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func DeleteWebhook(db *gorm.DB, id int) error {
+	return db.Delete(&models.Webhook{}, id).Error
+}
+
+func SetWebhookEnabled(db *gorm.DB, id int, enabled bool) error {
+	return db.Model(&models.Webhook{ID: id}).Update("enabled", enabled).Error
+}
+
+// GetWebhookDeliveries returns the latest deliveries, newest first
+func GetWebhookDeliveries(db *gorm.DB, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	if err := db.Order("id desc").Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func isWebhookEvent(event string) bool {
+	for _, known := range WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+func subscribes(webhook models.Webhook, event string) bool {
+	if webhook.Events == "" {
+		return true
+	}
+	for _, subscribed := range strings.Split(webhook.Events, ",") {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// FireWebhook logs a delivery of the event to every enabled webhook that
+// subscribes to it and hands them to the dispatcher to send
+func FireWebhook(db *gorm.DB, event string, data interface{}) {
+	var webhooks []models.Webhook
+	if err := db.Where("enabled = ?", true).Find(&webhooks).Error; err != nil {
+		log.Printf("Error fetching webhooks: %v", err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !subscribes(webhook, event) {
+			continue
+		}
+
+		delivery := models.WebhookDelivery{WebhookID: webhook.ID, Event: event}
+		if err := db.Create(&delivery).Error; err != nil {
+			log.Printf("Error logging webhook delivery: %v", err)
+			continue
+		}
+		body, err := json.Marshal(models.WebhookEnvelope{
+			Event:     event,
+			Delivery:  delivery.ID,
+			Timestamp: delivery.CreatedAt,
+			EventName: GetEventName(),
+			Data:      data,
+		})
+		if err != nil {
+			log.Printf("Error encoding %s webhook: %v", event, err)
+			continue
+		}
+		now := time.Now().UTC()
+		if err := db.Model(&delivery).Updates(map[string]interface{}{"payload": string(body), "next_attempt_at": &now}).Error; err != nil {
+			log.Printf("Error logging webhook delivery: %v", err)
+			continue
+		}
+		Webhooks.Wake()
+	}
+}
+
+// RedeliverWebhook sends a logged delivery again, with a fresh set of
+// retries, e.g. once the receiving site is back up after its retries ran out
+func RedeliverWebhook(db *gorm.DB, deliveryID int) error {
+	var delivery models.WebhookDelivery
+	if err := db.First(&delivery, deliveryID).Error; err != nil {
+		return errors.New("delivery not found")
+	}
+	var webhook models.Webhook
+	if err := db.First(&webhook, delivery.WebhookID).Error; err != nil {
+		return errors.New("webhook was deleted")
+	}
+	now := time.Now().UTC()
+	if err := db.Model(&delivery).Updates(map[string]interface{}{"retries": 0, "next_attempt_at": &now}).Error; err != nil {
+		return err
+	}
+	Webhooks.Wake()
+	return nil
+}
+
+// attemptWebhook sends a delivery once and logs the attempt. A failure that
+// may succeed later is scheduled for its next retry until they run out.
+func attemptWebhook(db *gorm.DB, delivery models.WebhookDelivery) {
+	var webhook models.Webhook
+	var status int
+	err := db.First(&webhook, delivery.WebhookID).Error
+	if err != nil {
+		err = errors.New("webhook was deleted")
+	} else {
+		status, err = sendWebhook(webhook, delivery)
+		delivery.Attempts++
+	}
+
+	delivery.StatusCode = status
+	delivery.Error = ""
+	delivery.NextAttemptAt = nil
+	if err != nil {
+		delivery.Error = err.Error()
+		if webhook.ID != 0 && retryableStatus(status) && delivery.Retries < len(webhookRetryDelays) {
+			next := time.Now().Add(webhookRetryDelays[delivery.Retries]).UTC()
+			delivery.NextAttemptAt = &next
+			delivery.Retries++
+		} else {
+			log.Printf("Webhook delivery %d to %s failed: %v", delivery.ID, webhook.URL, err)
+		}
+	} else {
+		now := time.Now()
+		delivery.Delivered = true
+		delivery.DeliveredAt = &now
+	}
+	if saveErr := db.Model(&delivery).Select("attempts", "status_code", "error", "delivered", "delivered_at", "retries", "next_attempt_at").Updates(&delivery).Error; saveErr != nil {
+		log.Printf("Error logging webhook delivery: %v", saveErr)
+	}
+}
+
+func sendWebhook(webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "MoSim-Event-Manager")
+	request.Header.Set("X-Webhook-Event", delivery.Event)
+	request.Header.Set("X-Webhook-Delivery", fmt.Sprint(delivery.ID))
+	request.Header.Set("X-Webhook-Signature", SignWebhook(webhook.Secret, body))
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver answered %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// retryableStatus reports whether a failed attempt may succeed later. Any
+// other client error means the receiver rejected the delivery.
+func retryableStatus(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// SignWebhook is the X-Webhook-Signature header for a body: the hex
+// HMAC-SHA256 of the body keyed with the webhook's secret
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func UserWebhookPayload(user models.User) models.WebhookUserPayload {
+	return models.WebhookUserPayload{
+		MMID:     user.MMID,
+		Username: user.Username,
+		Name:     DisplayName(user),
+	}
+}
+
+// ScheduleWebhookPayload lists every qualification match in order
+func ScheduleWebhookPayload(db *gorm.DB) models.WebhookSchedulePayload {
+	var matches []models.QualsMatch
+	if err := db.Order("id").Find(&matches).Error; err != nil {
+		log.Printf("Error fetching matches for webhook: %v", err)
+	}
+
+	payload := models.WebhookSchedulePayload{Matches: []models.WebhookScheduledMatch{}}
+	for _, match := range matches {
+		payload.Matches = append(payload.Matches, models.WebhookScheduledMatch{
+			MatchID:        match.ID,
+			RedMMID:        match.RedPlayerID,
+			BlueMMID:       match.BluePlayerID,
+			RedSurrogate:   match.RedSurrogate,
+			BlueSurrogate:  match.BlueSurrogate,
+			ScheduledStart: match.ScheduledStart,
+		})
+	}
+	return payload
+}
+
+func ScoreWebhookPayload(db *gorm.DB, match models.QualsMatch, corrected bool) models.WebhookScorePayload {
+	details := matchAnnouncement(db, "Quals", match)
+	redRP, blueRP := MatchRP(match)
+	return models.WebhookScorePayload{
+		MatchID:          match.ID,
+		RedName:          details.RedName,
+		BlueName:         details.BlueName,
+		RedScore:         match.RedScore,
+		BlueScore:        match.BlueScore,
+		RedAutoScore:     match.RedAutoScore,
+		BlueAutoScore:    match.BlueAutoScore,
+		RedTeleopScore:   match.RedTeleopScore,
+		BlueTeleopScore:  match.BlueTeleopScore,
+		RedEndgameScore:  match.RedEndgameScore,
+		BlueEndgameScore: match.BlueEndgameScore,
+		RedRP:            redRP,
+		BlueRP:           blueRP,
+		Corrected:        corrected,
+	}
+}
@@ -1,9 +1,7 @@
 package services
 
 import (
-	"encoding/json"
 	"log"
-	"net/http"
 	"sync"
 
 	"github.com/Jake-Schuler/MoSim-Event-Manager/models"
@@ -20,237 +18,121 @@ var current_match_state *models.WebSocketMatchPayload
 var current_leaderboard_state []models.User
 var current_alliance_selections []models.AllianceSelection
 
+// showStateMutex guards the state above. It is released before broadcasting,
+// because snapshots read the state while holding the hub's broadcast lock.
+var showStateMutex sync.RWMutex
+
+// showState is a copy of the state above, safe to use without the lock
+type showState struct {
+	eventName                string
+	match                    *models.WebSocketMatchPayload
+	leaderboard              []models.User
+	leaderboardVisible       bool
+	allianceSelectionVisible bool
+	allianceSelections       []models.AllianceSelection
+}
+
+func currentShowState() showState {
+	showStateMutex.RLock()
+	defer showStateMutex.RUnlock()
+
+	state := showState{
+		eventName:                event_name,
+		leaderboardVisible:       leaderboard_visible,
+		allianceSelectionVisible: alliance_selection_visible,
+		allianceSelections:       append([]models.AllianceSelection(nil), current_alliance_selections...),
+	}
+	if current_leaderboard_state != nil {
+		state.leaderboard = append(make([]models.User, 0, len(current_leaderboard_state)), current_leaderboard_state...)
+	}
+	if current_match_state != nil {
+		match := *current_match_state
+		state.match = &match
+	}
+	return state
+}
+
 // SetEventName updates the global event name
 func SetEventName(name string) {
+	showStateMutex.Lock()
+	defer showStateMutex.Unlock()
+
 	event_name = name
 	// Update current match state if it exists
 	if current_match_state != nil {
-		current_match_state.EventName = name
+		match := *current_match_state
+		match.EventName = name
+		current_match_state = &match
 	}
 }
 
+// CurrentMatch returns the match on the field, if any
+func CurrentMatch() (models.WebSocketMatchPayload, bool) {
+	showStateMutex.RLock()
+	defer showStateMutex.RUnlock()
+	if current_match_state == nil {
+		return models.WebSocketMatchPayload{}, false
+	}
+	return *current_match_state, true
+}
+
 // GetEventName returns the current event name
 func GetEventName() string {
+	showStateMutex.RLock()
+	defer showStateMutex.RUnlock()
 	return event_name
 }
 
 // GetLeaderboardVisibility returns the current leaderboard visibility state
 func GetLeaderboardVisibility() bool {
+	showStateMutex.RLock()
+	defer showStateMutex.RUnlock()
 	return leaderboard_visible
 }
 
 func ResetAllianceSelections() {
+	showStateMutex.Lock()
 	current_alliance_selections = nil
+	showStateMutex.Unlock()
 	log.Println("Reset current alliance selections")
 }
 
 var Upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		log.Printf("WebSocket upgrade check origin: %s", r.Header.Get("Origin"))
-		return true // Allow all origins for simplicity; adjust as needed
-	},
+	CheckOrigin:     CheckOrigin,
 }
 
-func HandleWebSocketConnection(conn *websocket.Conn, db *gorm.DB) {
-	defer func() {
-		Manager.RemoveConnection(conn)
-		conn.Close()
-	}()
-
-	log.Println("WebSocket connection established")
+// HandleWebSocketConnection serves a client until its connection closes
+func HandleWebSocketConnection(conn *websocket.Conn, db *gorm.DB, topics []string, role Role) {
+	client := NewClient(conn, topics, role)
+	log.Printf("WebSocket connection established with role %s", role)
 
 	// Add connection to manager
-	Manager.AddConnection(conn)
-
-	// Handle WebSocket messages in a loop
-	for {
-		_, message, err := conn.ReadMessage()
-		if err != nil {
-			log.Printf("WebSocket read error: %v", err)
-			break
-		}
-
-		log.Printf("Received message: %s", message)
-
-		// Parse JSON message
-		var wsMessage models.WebSocketMessage
-		err = json.Unmarshal(message, &wsMessage)
-		if err != nil {
-			log.Printf("Error parsing WebSocket message: %v", err)
-			continue
-		}
-
-		if wsMessage.Type == "statusbar_init" {
-			// Send initial status bar data - use stored state if available
-			var statusBarData models.WebSocketMatchPayload
-			if current_match_state != nil {
-				statusBarData = *current_match_state
-			} else {
-				statusBarData = models.WebSocketMatchPayload{
-					RedAlliance:  []string{""},
-					BlueAlliance: []string{""},
-					EventName:    event_name,
-					MatchLevel:   "",
-					MatchID:      0,
-				}
-			}
-
-			response := models.WebSocketMessage{
-				Type:    "active_match_update",
-				Payload: statusBarData,
-			}
-			err = conn.WriteJSON(response)
-			if err != nil {
-				log.Printf("WebSocket write error for status bar: %v", err)
-				break
-			}
-			log.Println("Sent initial status bar data")
-
-			// Send current leaderboard state if available
-			if current_leaderboard_state != nil {
-				leaderboardResponse := models.WebSocketMessage{
-					Type:    "leaderboard_update",
-					Payload: current_leaderboard_state,
-				}
-				err = conn.WriteJSON(leaderboardResponse)
-				if err != nil {
-					log.Printf("WebSocket write error for leaderboard: %v", err)
-				} else {
-					log.Println("Sent stored leaderboard data")
-				}
-			}
-
-			// Send current leaderboard visibility state
-			leaderboardToggle := models.WebSocketMessage{
-				Type: "leaderboard_toggle",
-				Payload: models.WebSocketLeaderboardTogglePayload{
-					Show: leaderboard_visible,
-				},
-			}
-			err = conn.WriteJSON(leaderboardToggle)
-			if err != nil {
-				log.Printf("WebSocket write error for leaderboard toggle: %v", err)
-			} else {
-				log.Printf("Sent leaderboard visibility state: %v", leaderboard_visible)
-			}
-
-			// Send current alliance selection visibility state
-			allianceToggle := models.WebSocketMessage{
-				Type: "alliance_selection_toggle",
-				Payload: models.WebSocketToggleAllianceSlectionPayload{
-					Show: alliance_selection_visible,
-				},
-			}
-			err = conn.WriteJSON(allianceToggle)
-			if err != nil {
-				log.Printf("WebSocket write error for alliance toggle: %v", err)
-			} else {
-				log.Printf("Sent alliance selection visibility state: %v", alliance_selection_visible)
-			}
+	Manager.AddConnection(client)
+	defer func() {
+		Manager.RemoveConnection(client)
+		client.Close()
+	}()
 
-			// Send current alliance selections if any exist
-			if len(current_alliance_selections) > 0 {
-				for _, selection := range current_alliance_selections {
-					if selection.AllianceCaptain != "" || selection.AllianceSelection != "" {
-						allianceResponse := models.WebSocketMessage{
-							Type: "alliance_selection",
-							Payload: models.WebSocketAllianceSelectionPayload{
-								AllianceNumber:    selection.AllianceNumber,
-								AllianceCaptain:   selection.AllianceCaptain,
-								AllianceSelection: selection.AllianceSelection,
-							},
-						}
-						err = conn.WriteJSON(allianceResponse)
-						if err != nil {
-							log.Printf("WebSocket write error for alliance selection: %v", err)
-						}
-					}
-				}
-				log.Println("Sent stored alliance selection data")
-			}
-		} else if wsMessage.Type == "request_available_teams" {
-			// Send available teams data
-			availableTeams := GetAvailableTeams(db)
-			response := models.WebSocketMessage{
-				Type: "available_teams_update",
-				Payload: map[string]interface{}{
-					"teams": availableTeams,
-				},
-			}
-			err = conn.WriteJSON(response)
-			if err != nil {
-				log.Printf("WebSocket write error for available teams: %v", err)
-				break
-			}
-			log.Println("Sent available teams data")
-		} else if wsMessage.Type == "team_selected" {
-			// Handle team selection from client
-			var payload map[string]interface{}
-			payloadBytes, _ := json.Marshal(wsMessage.Payload)
-			json.Unmarshal(payloadBytes, &payload)
-
-			if username, ok := payload["username"].(string); ok {
-				// Broadcast team selection to all clients
-				BroadcastTeamSelection(username)
-				log.Printf("Broadcasted team selection: %s", username)
-			}
-		}
-	}
+	go client.writePump()
+	client.readPump(db)
 
 	log.Println("WebSocket connection closed")
-} // WebSocket connection manager
-type ConnectionManager struct {
-	connections map[*websocket.Conn]bool
-	mutex       sync.RWMutex
-}
-
-var Manager = &ConnectionManager{
-	connections: make(map[*websocket.Conn]bool),
-}
-
-// Add connection to manager
-func (cm *ConnectionManager) AddConnection(conn *websocket.Conn) {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-	cm.connections[conn] = true
-	log.Printf("WebSocket connection added. Total connections: %d", len(cm.connections))
-}
-
-// Remove connection from manager
-func (cm *ConnectionManager) RemoveConnection(conn *websocket.Conn) {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-	delete(cm.connections, conn)
-	log.Printf("WebSocket connection removed. Total connections: %d", len(cm.connections))
-}
-
-// Broadcast message to all connected clients
-func (cm *ConnectionManager) Broadcast(message models.WebSocketMessage) {
-	cm.mutex.RLock()
-	defer cm.mutex.RUnlock()
-
-	for conn := range cm.connections {
-		err := conn.WriteJSON(message)
-		if err != nil {
-			log.Printf("Error broadcasting to client: %v", err)
-			// Remove dead connection
-			go func(c *websocket.Conn) {
-				cm.RemoveConnection(c)
-				c.Close()
-			}(conn)
-		}
-	}
 }
 
 // Broadcast active match update to all connected clients
 func BroadcastActiveMatch(matchLevel string, matchID int, redPlayerID string, bluePlayerID string, db *gorm.DB) {
 	var redPlayer, bluePlayer models.User
 
-	// Find users by MMID
-	db.Where("mm_id = ?", redPlayerID).First(&redPlayer)
-	db.Where("mm_id = ?", bluePlayerID).First(&bluePlayer)
+	// Find users by MMID. Playoff matches have none, and comparing an empty
+	// string with a number fails outright on PostgreSQL.
+	if redPlayerID != "" {
+		db.Where("mm_id = ?", redPlayerID).First(&redPlayer)
+	}
+	if bluePlayerID != "" {
+		db.Where("mm_id = ?", bluePlayerID).First(&bluePlayer)
+	}
 
 	// Use preferred username if available, otherwise fall back to username
 	redUsername := redPlayer.PreferedUsername
@@ -266,19 +148,32 @@ func BroadcastActiveMatch(matchLevel string, matchID int, redPlayerID string, bl
 	payload := models.WebSocketMatchPayload{
 		MatchLevel:   matchLevel,
 		MatchID:      matchID,
-		EventName:    event_name,
+		EventName:    GetEventName(),
 		RedAlliance:  []string{redUsername},
 		BlueAlliance: []string{blueUsername},
 	}
+	if matchLevel == "Quals" {
+		var match models.QualsMatch
+		if err := db.First(&match, matchID).Error; err == nil {
+			payload.RedSurrogate = match.RedSurrogate
+			payload.BlueSurrogate = match.BlueSurrogate
+		}
+	}
 
 	// Store the current match state
+	showStateMutex.Lock()
 	current_match_state = &payload
+	showStateMutex.Unlock()
 
-	message := models.WebSocketMessage{
-		Type:    "active_match_update",
-		Payload: payload,
+	message := NewMessage("active_match_update", payload)
+	topics := []string{TopicMatch}
+	if redPlayer.MMID != 0 {
+		topics = append(topics, PlayerTopic(redPlayer.MMID))
 	}
-	Manager.Broadcast(message)
+	if bluePlayer.MMID != 0 {
+		topics = append(topics, PlayerTopic(bluePlayer.MMID))
+	}
+	Manager.BroadcastTo(topics, message)
 	log.Printf("Broadcasted active match update: Level=%s, ID=%d, Red=%s, Blue=%s", matchLevel, matchID, redUsername, blueUsername)
 }
 
@@ -290,40 +185,35 @@ func BroadcastLeaderboardUpdate(db *gorm.DB) {
 	}
 
 	// Store the current leaderboard state
+	showStateMutex.Lock()
 	current_leaderboard_state = leaderboard
+	showStateMutex.Unlock()
 
-	message := models.WebSocketMessage{
-		Type:    "leaderboard_update",
-		Payload: leaderboard,
-	}
+	message := NewMessage("leaderboard_update", models.WebSocketLeaderboardPayload{
+		Users: leaderboard,
+	})
 	Manager.Broadcast(message)
 	log.Printf("Broadcasted leaderboard update: %d users", len(leaderboard))
 }
 
 func ToggleLeaderboardVisibility() {
+	showStateMutex.Lock()
 	leaderboard_visible = !leaderboard_visible // Toggle the global state
+	show := leaderboard_visible
+	showStateMutex.Unlock()
+
 	payload := models.WebSocketLeaderboardTogglePayload{
-		Show: leaderboard_visible,
-	}
-	message := models.WebSocketMessage{
-		Type:    "leaderboard_toggle",
-		Payload: payload,
+		Show: show,
 	}
+	message := NewMessage("leaderboard_toggle", payload)
 	Manager.Broadcast(message)
-	log.Printf("Broadcasted leaderboard visibility toggle: %v", leaderboard_visible)
+	log.Printf("Broadcasted leaderboard visibility toggle: %v", show)
 }
 
-func EndScreenBroadcast(redAlliance []string, blueAlliance []string) {
-	payload := models.WebSocketMatchSavedPayload{
-		RedAlliance:  redAlliance,
-		BlueAlliance: blueAlliance,
-	}
-	message := models.WebSocketMessage{
-		Type:    "match_saved",
-		Payload: payload,
-	}
+func EndScreenBroadcast(payload models.WebSocketMatchSavedPayload) {
+	message := NewMessage("match_saved", payload)
 	Manager.Broadcast(message)
-	log.Printf("Broadcasted end screen match saved: Red=%v, Blue=%v", redAlliance, blueAlliance)
+	log.Printf("Broadcasted end screen match saved: Red=%v, Blue=%v", payload.RedAlliance, payload.BlueAlliance)
 
 	// Clear the current match state since the match has ended
 	ClearMatchState()
@@ -339,65 +229,37 @@ func BroadcastAllianceSelection(allianceSelection models.AllianceSelection) {
 	// Update current alliance selections state
 	updateCurrentAllianceSelections(allianceSelection)
 
-	message := models.WebSocketMessage{
-		Type:    "alliance_selection",
-		Payload: payload,
-	}
+	message := NewMessage("alliance_selection", payload)
 	Manager.Broadcast(message)
 	log.Printf("Broadcasted alliance selection: %d, Captain=%s, Selection=%s",
 		allianceSelection.AllianceNumber, allianceSelection.AllianceCaptain, allianceSelection.AllianceSelection)
 }
 
 func ToggleAllianceSelectionVisibility() {
+	showStateMutex.Lock()
 	alliance_selection_visible = !alliance_selection_visible
+	show := alliance_selection_visible
+	showStateMutex.Unlock()
+
 	payload := models.WebSocketToggleAllianceSlectionPayload{
-		Show: alliance_selection_visible,
-	}
-	message := models.WebSocketMessage{
-		Type:    "alliance_selection_toggle",
-		Payload: payload,
+		Show: show,
 	}
+	message := NewMessage("alliance_selection_toggle", payload)
 	Manager.Broadcast(message)
-	log.Printf("Broadcasted alliance selection visibility toggle: %v", alliance_selection_visible)
+	log.Printf("Broadcasted alliance selection visibility toggle: %v", show)
 }
 
 // GetAvailableTeams returns users that haven't been selected for alliances yet
 func GetAvailableTeams(db *gorm.DB) []models.User {
-	var allUsers []models.User
 	var selectedUsers []string
 
-	// Get all users from database
-	if err := db.Find(&allUsers).Error; err != nil {
+	// Get every user, ranked
+	allUsers, err := GetLeaderboard(db)
+	if err != nil {
 		log.Printf("Error fetching users: %v", err)
 		return []models.User{}
 	}
 
-	// Calculate RP and stats for each user to determine rank
-	for i := range allUsers {
-		winRP, bonusRP, AutoPoints, TeleopPoints, EndgamePoints := calculateUserStats(db, allUsers[i].MMID)
-		allUsers[i].WinRP = winRP
-		allUsers[i].BonusRP = bonusRP
-		allUsers[i].TotalRP = winRP + bonusRP
-		allUsers[i].TotalPoints = AutoPoints + TeleopPoints + EndgamePoints
-		allUsers[i].AutoPoints = AutoPoints
-		allUsers[i].TeleopPoints = TeleopPoints
-		allUsers[i].EndgamePoints = EndgamePoints
-	}
-
-	// Sort users by TotalRP (descending) to assign ranks
-	for i := 0; i < len(allUsers)-1; i++ {
-		for j := i + 1; j < len(allUsers); j++ {
-			if allUsers[i].TotalRP < allUsers[j].TotalRP {
-				allUsers[i], allUsers[j] = allUsers[j], allUsers[i]
-			}
-		}
-	}
-
-	// Assign ranks based on the sorted order
-	for i := range allUsers {
-		allUsers[i].Rank = i + 1
-	}
-
 	// Get all alliance selections to find already selected users
 	var allianceSelections []models.AllianceSelection
 	if err := db.Find(&allianceSelections).Error; err != nil {
@@ -445,19 +307,19 @@ func GetAvailableTeams(db *gorm.DB) []models.User {
 
 // BroadcastTeamSelection notifies all clients that a team has been selected
 func BroadcastTeamSelection(username string) {
-	payload := map[string]interface{}{
-		"username": username,
-	}
-	message := models.WebSocketMessage{
-		Type:    "team_selection_made",
-		Payload: payload,
+	payload := models.WebSocketTeamSelectionPayload{
+		Username: username,
 	}
+	message := NewMessage("team_selection_made", payload)
 	Manager.Broadcast(message)
 	log.Printf("Broadcasted team selection: %s", username)
 }
 
 // updateCurrentAllianceSelections updates the current alliance selections state
 func updateCurrentAllianceSelections(newSelection models.AllianceSelection) {
+	showStateMutex.Lock()
+	defer showStateMutex.Unlock()
+
 	if current_alliance_selections == nil {
 		current_alliance_selections = make([]models.AllianceSelection, 0)
 	}
@@ -486,13 +348,20 @@ func InitializeWebSocketState(db *gorm.DB) {
 	if err := db.Find(&allianceSelections).Error; err != nil {
 		log.Printf("Error loading alliance selections: %v", err)
 	} else {
+		showStateMutex.Lock()
 		current_alliance_selections = allianceSelections
+		showStateMutex.Unlock()
 		log.Printf("Loaded %d alliance selections", len(allianceSelections))
 	}
 
+	// Load the match queue
+	Queue.Broadcast(db)
+
 	// Load current leaderboard
 	if leaderboard, err := GetLeaderboard(db); err == nil {
+		showStateMutex.Lock()
 		current_leaderboard_state = leaderboard
+		showStateMutex.Unlock()
 		log.Printf("Loaded leaderboard with %d users", len(leaderboard))
 	} else {
 		log.Printf("Error loading leaderboard: %v", err)
@@ -503,7 +372,9 @@ func InitializeWebSocketState(db *gorm.DB) {
 
 // ClearMatchState clears the current match state (useful when match ends)
 func ClearMatchState() {
+	showStateMutex.Lock()
 	current_match_state = nil
 	current_alliance_selections = nil
+	showStateMutex.Unlock()
 	log.Println("Cleared current match state and alliance selections")
 }